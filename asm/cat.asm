@@ -0,0 +1,41 @@
+; Prints the start of a file on the drive, named by the first argument.
+; Run with a drive attached, e.g. "svc -disk drive.img cat.svb hello.txt".
+
+not_found = "file not found"
+
+. lib/io.asm
+. lib/disk.asm
+
+main:
+
+  ; The first argument is stored at the start of the heap.
+  ; The sector buffer is stored well after it.
+  ldr ra (0xffff)
+  cpl rb 0x8000
+
+  ; Find the file, printing an error if it does not exist.
+  cal {find_file}
+  cml ac 0
+  gtn &found_main
+  cpl ra [not_found]
+  cpl rb 0
+  cal {print}
+  vga, ret
+
+  ; Read the first sector, terminating the contents after the file size.
+  &found_main
+  psh ex
+  cop ra ac
+  cal {read_sector}
+  pop ac
+  cml ac 256
+  gte &print_main
+  add rb
+  str ac (0)
+
+  ; Print the contents.
+  &print_main
+  cop ra rb
+  cpl rb 0
+  cal {print}
+  vga, ret
@@ -0,0 +1,47 @@
+; Echoes typed keys to the screen until enter is pressed.
+; Run with a keyboard attached, e.g. "svc -kbd keyboard.svb".
+
+; Handles keyboard interrupts by printing the key.
+; rb = Address to print the next key to.
+; rc = Set to 1 when enter is pressed.
+on_key:
+  psh ac, ra
+
+  ; Load the key, checking for enter.
+  ldr ac (0xfff1)
+  cml ac 0x0a
+  gte &enter_on_key
+
+  ; Print the key code (the low byte of the key) and draw the text buffer.
+  and (0x00ff)
+  orr (0x0f00)
+  str rb ac
+  inc rb
+  vga
+  gto &ack_on_key
+
+  &enter_on_key
+  cpl rc 1
+
+  ; Acknowledge the key so the next one can be made available.
+  &ack_on_key
+  cpl ra 0xfff0
+  str ra (0)
+  pop ra, ac
+  rti
+
+main:
+
+  ; Set the keyboard interrupt handler (line 1).
+  cpl ra {on_key}
+  str (0xffe1) ra
+
+  ; Wait for keys until enter is pressed.
+  cpl rb 0
+  cpl rc 0
+  eni
+  &loop_main
+  wfi
+  cml rc 1
+  gtn &loop_main
+  ret
@@ -0,0 +1,539 @@
+; This file is intended to be sourced by another.
+; It defines subroutines for using the drive and its filesystem.
+; See the "Drive" section of the main README.md for the filesystem layout.
+
+; Reads a sector from the drive.
+; ra = Sector number.
+; rb = Address of a 256 word buffer to read into.
+; Sets ac to the drive status (0 on success).
+read_sector:
+  psh rc
+  cpl rc 0xfff4
+  str rc ra
+  cpl rc 0xfff5
+  str rc rb
+  cpl rc 0xfff2
+  str rc (1)
+
+  ; Wait for the command to finish.
+  &loop_read_sector
+  ldr ac rc
+  cml ac 0
+  gtn &loop_read_sector
+
+  ldr ac (0xfff3)
+  pop rc
+  ret
+
+; Writes a sector to the drive.
+; ra = Sector number.
+; rb = Address of a 256 word buffer to write from.
+; Sets ac to the drive status (0 on success).
+write_sector:
+  psh rc
+  cpl rc 0xfff4
+  str rc ra
+  cpl rc 0xfff5
+  str rc rb
+  cpl rc 0xfff2
+  str rc (2)
+
+  ; Wait for the command to finish.
+  &loop_write_sector
+  ldr ac rc
+  cml ac 0
+  gtn &loop_write_sector
+
+  ldr ac (0xfff3)
+  pop rc
+  ret
+
+; Finds the sector that follows another in a file.
+; ra = Sector number.
+; rb = Address of a 256 word buffer to use.
+; Sets ac to the next sector, or 0xffff if ra is the last.
+next_sector:
+  psh ra, rc
+
+  ; The FAT starts at sector 1 and holds one word per sector.
+  cop rc ra
+  cop ac ra
+  shr ac 8
+  inc ac
+  cop ra ac
+  cal {read_sector}
+  cop ac rc
+  and (0x00ff)
+  add rb
+  ldr ac ac
+
+  pop rc, ra
+  ret
+
+; Checks if a file name matches a directory entry.
+; ra = Address of the file name string.
+; re = Address of the directory entry.
+; Sets ac to 1 if the names match, else 0.
+name_matches:
+  psh rb, rc
+  psh rd, rh
+  cop rb ra
+  cop rc re
+  cpl rh 12
+
+  ; Compare up to 12 characters.
+  &loop_name_matches
+  cml rh 0
+  gte &end_name_matches
+  ldr rd rb
+  ldr ac rc
+  cmp ac rd
+  gtn &no_name_matches
+  cml ac 0
+  gte &yes_name_matches
+  inc rb, rc
+  dec rh
+  gto &loop_name_matches
+
+  ; A name with 12 characters matches if the string ends there too.
+  &end_name_matches
+  ldr ac rb
+  cml ac 0
+  gte &yes_name_matches
+
+  &no_name_matches
+  cpl ac 0
+  gto &ret_name_matches
+  &yes_name_matches
+  cpl ac 1
+  &ret_name_matches
+  pop rh, rd
+  pop rc, rb
+  ret
+
+; Finds the directory entry of a file, reading the directory sector
+;   holding it into the buffer.
+; ra = Address of the file name string, or of an empty string to find
+;   an unused entry.
+; rb = Address of a 256 word buffer to use.
+; Sets ac to the directory sector and ex to the address of the entry
+;   in the buffer, or ac to 0 if there is no such entry.
+find_entry:
+  psh rc, rd
+  psh re, rf
+
+  ; Read the superblock to find the directory table.
+  ; rc = Current directory sector.
+  ; rd = Number of directory sectors left.
+  psh ra
+  cpl ra 0
+  cal {read_sector}
+  pop ra
+  cop ac rb
+  add (5)
+  ldr rc ac
+  cop ac rb
+  add (6)
+  ldr rd ac
+
+  &loop_find_entry
+  cml rd 0
+  gte &missing_find_entry
+  psh ra
+  cop ra rc
+  cal {read_sector}
+  pop ra
+
+  ; Check each of the 16 entries in the sector.
+  ; re = Address of the entry.
+  ; rf = Number of entries left.
+  cop re rb
+  cpl rf 16
+  &loop_entry_find_entry
+  cml rf 0
+  gte &next_find_entry
+  cal {name_matches}
+  cml ac 1
+  gte &found_find_entry
+  cop ac re
+  add (16)
+  cop re ac
+  dec rf
+  gto &loop_entry_find_entry
+
+  &next_find_entry
+  inc rc
+  dec rd
+  gto &loop_find_entry
+
+  &found_find_entry
+  cop ex re
+  cop ac rc
+  gto &ret_find_entry
+
+  &missing_find_entry
+  cpl ac 0
+
+  &ret_find_entry
+  pop rf, re
+  pop rd, rc
+  ret
+
+; Finds a file in the directory table.
+; ra = Address of the file name string.
+; rb = Address of a 256 word buffer to use.
+; Sets ac to the first sector of the file and ex to its size in words,
+;   or ac to 0 if the file does not exist.
+; Empty files have no sectors, so ac is also 0 for them.
+find_file:
+  psh rc
+  cal {find_entry}
+  cml ac 0
+  gte &ret_find_file
+
+  ; Load the first sector and size of the file.
+  cop ac ex
+  add (12)
+  ldr rc ac
+  inc ac
+  ldr ex ac
+  cop ac rc
+
+  &ret_find_file
+  pop rc
+  ret
+
+; Sets the FAT word of a sector.
+; ra = Sector number.
+; rb = Address of a 256 word buffer to use.
+; rc = Value to set it to.
+; Sets ac to the drive status (0 on success).
+set_fat:
+  psh ra, rd
+
+  ; The FAT starts at sector 1 and holds one word per sector.
+  cop rd ra
+  cop ac ra
+  shr ac 8
+  inc ac
+  cop ra ac
+  cal {read_sector}
+  cml ac 0
+  gtn &ret_set_fat
+  cop ac rd
+  and (0x00ff)
+  add rb
+  str ac rc
+  cal {write_sector}
+
+  &ret_set_fat
+  pop rd, ra
+  ret
+
+; Allocates a free sector, marking it as the last sector of a file.
+; rb = Address of a 256 word buffer to use.
+; Sets ac to the sector number, or 0 if the drive is full.
+alloc_sector:
+  psh ra, rc
+  psh rd, re
+
+  ; Read the superblock to find the data sectors.
+  ; rc = Current sector.
+  ; rd = Number of sectors.
+  ; re = FAT sector in the buffer.
+  cpl ra 0
+  cal {read_sector}
+  cop ac rb
+  add (7)
+  ldr rc ac
+  cop ac rb
+  add (2)
+  ldr rd ac
+  cpl re 0
+
+  &loop_alloc_sector
+  cmp rc rd
+  gte &full_alloc_sector
+
+  ; Read the FAT sector holding the word of the current sector,
+  ;   unless it is already in the buffer.
+  cop ac rc
+  shr ac 8
+  inc ac
+  cmp ac re
+  gte &check_alloc_sector
+  cop re ac
+  cop ra ac
+  cal {read_sector}
+
+  &check_alloc_sector
+  cop ac rc
+  and (0x00ff)
+  add rb
+  ldr ac ac
+  cml ac 0
+  gte &found_alloc_sector
+  inc rc
+  gto &loop_alloc_sector
+
+  ; Mark the sector as the last of a file.
+  &found_alloc_sector
+  cop ra rc
+  cpl rc 0xffff
+  cal {set_fat}
+  cml ac 0
+  gtn &full_alloc_sector
+  cop ac ra
+  gto &ret_alloc_sector
+
+  &full_alloc_sector
+  cpl ac 0
+
+  &ret_alloc_sector
+  pop re, rd
+  pop rc, ra
+  ret
+
+; Creates an empty file.
+; ra = Address of the file name string, which must be 1-12 characters.
+; rb = Address of a 256 word buffer to use.
+; Sets ac to 1 on success, or 0 if the name is invalid, the file
+;   already exists, or the directory table is full.
+create_file:
+  psh ra, rc
+  psh rd, re
+  psh rh
+  cop rh ra
+
+  ; Check the length of the name.
+  ; rc = Address of the character.
+  ; rd = Number of characters.
+  cop rc ra
+  cpl rd 0
+  &loop_length_create_file
+  ldr ac rc
+  cml ac 0
+  gte &length_create_file
+  inc rc, rd
+  gto &loop_length_create_file
+  &length_create_file
+  cml rd 0
+  gte &fail_create_file
+  cml rd 12
+  gab &fail_create_file
+
+  ; Check that the file does not exist.
+  cal {find_entry}
+  cml ac 0
+  gtn &fail_create_file
+
+  ; Find an unused entry, which has an empty name.
+  ; The empty name is stored on the stack.
+  cpl ra 0
+  psh ra
+  cop ra sp
+  cal {find_entry}
+  pop ra
+  cml ac 0
+  gte &fail_create_file
+  psh ac
+
+  ; Copy the name into the entry, padding it with null words.
+  ; rc = Address of the character.
+  ; rd = Number of words left.
+  ; re = Address in the entry.
+  cop rc rh
+  cpl rd 12
+  cop re ex
+  &loop_name_create_file
+  cml rd 0
+  gte &size_create_file
+  ldr ac rc
+  str re ac
+  inc re
+  dec rd
+  cml ac 0
+  gte &loop_name_create_file
+  inc rc
+  gto &loop_name_create_file
+
+  ; The file has no sectors and is empty.
+  &size_create_file
+  str re (0)
+  inc re
+  str re (0)
+
+  ; Write the directory sector.
+  pop ra
+  cal {write_sector}
+  cml ac 0
+  gtn &fail_create_file
+  cpl ac 1
+  gto &ret_create_file
+
+  &fail_create_file
+  cpl ac 0
+
+  &ret_create_file
+  pop rh
+  pop re, rd
+  pop rc, ra
+  ret
+
+; Appends words to a file, allocating sectors for it as needed.
+; ra = Address of the file name string.
+; rb = Address of a 256 word buffer to use.
+; rc = Address of the words to append.
+; rd = Number of words to append.
+; Sets ac to 1 on success, or 0 if the file does not exist, would grow
+;   past 0xffff words, or the drive is full or fails.
+; If the drive fills up, the words that fit are still appended.
+append_file:
+  psh ra, rc
+  psh rd, re
+  psh rf, rh
+  psh ri
+  cpl ri 1
+
+  ; Find the entry of the file.
+  cal {find_entry}
+  cml ac 0
+  gtn &found_append_file
+  cpl ri 0
+  gto &ret_append_file
+
+  ; Save the directory sector and the offset of the entry in the buffer,
+  ;   and load the first sector and size of the file.
+  ; rf = Size of the file.
+  ; rh = First sector of the file, or 0 if it has none.
+  &found_append_file
+  psh ac
+  cop ac ex
+  sub rb
+  psh ac
+  cop ac ex
+  add (12)
+  ldr rh ac
+  inc ac
+  ldr rf ac
+
+  ; Check that the file will not grow past 0xffff words.
+  cop ac rf
+  add rd
+  gcs &fail_append_file
+
+  ; Find the last sector of the file.
+  ; re = Last sector of the file, or 0 if it has none.
+  cop re rh
+  cml re 0
+  gte &loop_append_file
+  &last_append_file
+  cop ra re
+  cal {next_sector}
+  cml ac 0xffff
+  gte &loop_append_file
+  cop re ac
+  gto &last_append_file
+
+  ; Append the words a sector at a time.
+  &loop_append_file
+  cml rd 0
+  gte &update_append_file
+
+  ; Read the last sector if it has room, else allocate a new one
+  ;   and link it to the end of the file.
+  cop ac rf
+  and (0x00ff)
+  cml ac 0
+  gte &alloc_append_file
+  cop ra re
+  cal {read_sector}
+  cml ac 0
+  gtn &fail_append_file
+  gto &copy_append_file
+
+  &alloc_append_file
+  cal {alloc_sector}
+  cml ac 0
+  gte &fail_append_file
+  cop ra ac
+  cml re 0
+  gtn &link_append_file
+  cop rh ra
+  gto &new_append_file
+  &link_append_file
+  psh rc
+  cop rc ra
+  cop ra re
+  cal {set_fat}
+  cop ra rc
+  pop rc
+  cml ac 0
+  gtn &fail_append_file
+  &new_append_file
+  cop re ra
+
+  ; Clear the buffer, so the rest of the new sector is null.
+  cop ra rb
+  cop ac rb
+  add (256)
+  &loop_clear_append_file
+  cmp ra ac
+  gte &copy_append_file
+  str ra (0)
+  inc ra
+  gto &loop_clear_append_file
+
+  ; Copy words into the sector until it is full.
+  ; ra = Address in the buffer.
+  &copy_append_file
+  cop ac rf
+  and (0x00ff)
+  add rb
+  cop ra ac
+  &loop_copy_append_file
+  ldr ac rc
+  str ra ac
+  inc ra, rc
+  inc rf
+  dec rd
+  cml rd 0
+  gte &write_append_file
+  cop ac rf
+  and (0x00ff)
+  cml ac 0
+  gtn &loop_copy_append_file
+
+  &write_append_file
+  cop ra re
+  cal {write_sector}
+  cml ac 0
+  gte &loop_append_file
+
+  &fail_append_file
+  cpl ri 0
+
+  ; Write the first sector and size to the entry.
+  &update_append_file
+  pop ac
+  pop ra
+  psh ac
+  cal {read_sector}
+  pop ac
+  add rb
+  add (12)
+  str ac rh
+  inc ac
+  str ac rf
+  cal {write_sector}
+  cml ac 0
+  gte &ret_append_file
+  cpl ri 0
+
+  &ret_append_file
+  cop ac ri
+  pop ri
+  pop rh, rf
+  pop re, rd
+  pop rc, ra
+  ret
@@ -0,0 +1,68 @@
+; Appends a line of text to a file on the drive, creating the file if it
+;   does not exist.
+; Run with a drive attached, e.g. "svc -disk drive.img log.svb notes.txt hello".
+
+usage = "usage: log.svb <file> <text>"
+failed = "could not write the file"
+
+. lib/io.asm
+. lib/disk.asm
+
+main:
+
+  ; Check that there are two arguments.
+  ldr ac (0xfffd)
+  cml ac 2
+  gte &args_main
+  cpl ra [usage]
+  gto &fail_main
+
+  ; The arguments are stored one after another at the start of the heap.
+  ; The sector buffer is stored well after them.
+  ; ra = Address of the file name.
+  ; rc = Address of the text.
+  &args_main
+  ldr ra (0xffff)
+  cpl rb 0x8000
+  cop rc ra
+  &loop_name_main
+  ldr ac rc
+  inc rc
+  cml ac 0
+  gtn &loop_name_main
+
+  ; Create the file, which fails if it already exists.
+  cal {create_file}
+
+  ; Replace the null word after the text with a newline.
+  ; rd = Number of words to append.
+  cop re rc
+  &loop_text_main
+  ldr ac re
+  cml ac 0
+  gte &end_text_main
+  inc re
+  gto &loop_text_main
+  &end_text_main
+  str re (10)
+  inc re
+  cop ac re
+  sub rc
+  cop rd ac
+
+  ; Append the line.
+  cal {append_file}
+  cml ac 1
+  gtn &error_main
+  ret
+
+  &error_main
+  cpl ra [failed]
+
+  ; Print the message and exit with code 1.
+  &fail_main
+  cpl rb 0
+  cal {print}
+  vga
+  cpl ra 1
+  hlt ra
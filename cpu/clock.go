@@ -0,0 +1,46 @@
+package cpu
+
+import (
+	"context"
+	"time"
+)
+
+// clock keeps execution to the CPU's clock rate.
+type clock struct {
+	// start is when cycles was base.
+	start time.Time
+	base  uint64
+}
+
+// reset makes the clock count from the current time.
+func (k *clock) reset(cycles uint64) {
+	k.start = time.Now()
+	k.base = cycles
+}
+
+// pace sleeps if more than cycles have been executed than the clock rate
+//   allows for the time since the clock was reset.
+// It checks about 100 times per emulated second.
+func (c *CPU) pace(ctx context.Context, k *clock, cycles uint64) error {
+	interval := c.ClockRate / 100
+	if interval == 0 {
+		interval = 1
+	}
+	n := cycles - k.base
+	if n%interval != 0 {
+		return nil
+	}
+	expected := time.Duration(float64(n) / float64(c.ClockRate) * float64(time.Second))
+	d := expected - time.Since(k.start)
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -3,252 +3,295 @@
 package cpu
 
 import (
+	"context"
 	"github.com/tteeoo/svc/dat"
 	"github.com/tteeoo/svc/mem"
 	"github.com/tteeoo/svc/vga"
+	"io"
 	"os"
 )
 
+// cancelCheckInterval is the number of cycles executed between
+//   checks of the context passed to Run.
+const cancelCheckInterval = 1024
+
+// HaltReason describes why execution stopped.
+type HaltReason int
+
+const (
+	// HaltReturn means the program returned to the exit address (0xffff).
+	HaltReturn HaltReason = iota
+	// HaltCanceled means the context passed to Run was canceled.
+	HaltCanceled
+	// HaltFault means an instruction faulted with no guest handler set.
+	HaltFault
+	// HaltExit means the program halted itself with an exit code.
+	HaltExit
+)
+
+// String returns a human-readable halt reason.
+func (h HaltReason) String() string {
+	switch h {
+	case HaltReturn:
+		return "return"
+	case HaltCanceled:
+		return "canceled"
+	case HaltFault:
+		return "fault"
+	case HaltExit:
+		return "exit"
+	}
+	return "unknown"
+}
+
+// Result describes the state of the CPU after execution stops.
+type Result struct {
+	// Reason is why execution stopped.
+	Reason HaltReason
+	// Regs is a copy of the registers when execution stopped.
+	Regs [dat.RegNum]uint16
+	// Cycles is the number of instructions that were executed by the call.
+	Cycles uint64
+	// ExitCode is the exit code the program halted with, or 0 if it returned.
+	ExitCode uint16
+}
+
 // CPU is a basic implementation of a CPU.
 type CPU struct {
-	// Mem is the main memory device used by the CPU.
-	Mem *mem.RAM
+	// Mem is the memory bus used by the CPU.
+	Mem *mem.Bus
 	// VGA is the main video device used by the CPU.
+	// If it is nil, vga does nothing, so programs can run without a screen.
 	VGA *vga.VGA
-	// Regs maps numbers to regsiter values.
-	Regs map[uint16]uint16
+	// Regs holds the register values, indexed by number.
+	Regs [dat.RegNum]uint16
+	// IRQ is the interrupt controller used by the CPU.
+	IRQ *Interrupts
+	// Syscalls maps numbers to the syscalls used by the sys instruction.
+	Syscalls map[uint16]Syscall
+	// Cycles is the number of instructions executed since the CPU was booted.
+	Cycles uint64
+	// ClockRate is the number of instructions Run executes per second,
+	//   or 0 to execute them as fast as possible.
+	ClockRate uint64
+	// Poll, if set, is called by Resume before each instruction is executed
+	//   and before waiting for interrupts, so inputs can be delivered at
+	//   points that do not depend on timing. A returned error stops execution.
+	Poll func(c *CPU) error
+	// Stdin and Stdout are used by the default syscalls.
+	// Stdin should not also be read by a device, such as a keyboard.
+	Stdin  io.Reader
+	Stdout io.Writer
+	// current is the instruction being executed.
+	current Instruction
+	// observers are notified as instructions execute.
+	observers []Observer
+	// halted is true if Halt was called, and exitCode holds its argument.
+	halted   bool
+	exitCode uint16
 }
 
-// NewCPU returns a pointer to a newly initialized CPU.
-func NewCPU(m *mem.RAM, v *vga.VGA) *CPU {
+// Instruction is a decoded instruction.
+type Instruction struct {
+	// PC is the address of the instruction.
+	PC uint16
+	// Word is the opcode word, including packed operands.
+	Word uint16
+	// Info describes the encoding of the instruction.
+	Info *dat.OpInfo
+	// Operands holds the packed operands followed by the extra operands.
+	Operands [MaxOperands]uint16
+}
 
-	// Create registers
-	regs := make(map[uint16]uint16)
-	for _, n := range dat.RegNamesToNum {
-		regs[n] = 0
+// Args returns the operands used by the instruction.
+func (in *Instruction) Args() []uint16 {
+	if in.Info == nil {
+		return nil
 	}
-	regs[dat.RegNamesToNum["sp"]] = m.StackMax
+	return in.Operands[:in.Info.Packed+in.Info.Size]
+}
 
-	return &CPU{
-		Mem:  m,
-		VGA:  v,
-		Regs: regs,
+// NewCPU returns a pointer to a newly initialized CPU.
+func NewCPU(m *mem.Bus, v *vga.VGA) *CPU {
+	c := &CPU{
+		Mem:      m,
+		VGA:      v,
+		IRQ:      NewInterrupts(),
+		Syscalls: DefaultSyscalls(),
+		Stdin:    os.Stdin,
+		Stdout:   os.Stdout,
 	}
+	c.Regs[dat.SP] = m.StackMax
+	return c
 }
 
-// Run starts execution at the given memory address.
-func (c *CPU) Run(address uint16) {
-
-	// Put command-line args into heap
-	var l uint16
-	if len(os.Args) > 2 {
-		i := c.Mem.HeapOffset
-		for _, str := range os.Args[2:] {
-			for _, char := range str {
-				c.Mem.Set(i, uint16(char))
-				i++
-			}
-			c.Mem.Set(i, 0)
-			i++
-		}
-		l = uint16(i - c.Mem.HeapOffset)
-
-		// Load heap information
-		c.Mem.Set(0xfffe, l)
-		c.Mem.Set(0xfffd, uint16(len(os.Args)-2))
+// Run boots the CPU and starts execution at the given memory address,
+//   blocking until the program returns to the exit address or halts, ctx is
+//   canceled, or an instruction faults without a guest fault handler.
+// If the CPU cannot be booted, Run returns the error from Boot without
+//   executing anything.
+func (c *CPU) Run(ctx context.Context, address uint16, s Startup) (Result, error) {
+	if err := c.Boot(address, s); err != nil {
+		return Result{}, err
 	}
-	c.Mem.Set(0xffff, c.Mem.HeapOffset)
-
-	// Push exit address onto stack
-	sp := dat.RegNamesToNum["sp"]
-	c.Mem.Set(c.Regs[sp], 0xffff)
+	return c.Resume(ctx)
+}
 
-	// Set the program counter
-	c.Regs[dat.RegNamesToNum["pc"]] = address
+// Resume continues execution from the current state of the CPU,
+//   blocking like Run.
+// It can be used to continue after Run was canceled, or after the state
+//   of the CPU has been restored.
+func (c *CPU) Resume(ctx context.Context) (Result, error) {
 
 	// Enter the execution loop
+	start := c.Cycles
+	var k clock
+	k.reset(c.Cycles)
 	for {
-		pc := c.Regs[dat.RegNamesToNum["pc"]]
+		cycles := c.Cycles - start
+
+		// Stop if pc is the last address or the program halted
+		if c.Regs[dat.PC] == 0xffff {
+			return c.result(HaltReturn, cycles), nil
+		}
+		if c.halted {
+			return c.result(HaltExit, cycles), nil
+		}
+
+		// Stop if canceled
+		if cycles%cancelCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return c.result(HaltCanceled, cycles), err
+			}
+		}
+
+		// Deliver inputs
+		if c.Poll != nil {
+			if err := c.Poll(c); err != nil {
+				return c.result(HaltCanceled, cycles), err
+			}
+		}
+
+		// Wait for interrupts after wfi
+		if c.IRQ.waiting && c.IRQ.Pending() == 0 {
+			if err := c.IRQ.wait(ctx); err != nil {
+				return c.result(HaltCanceled, cycles), err
+			}
+			k.reset(c.Cycles)
+			continue
+		}
 
-		// Exit if pc is the last address
-		if pc == 0xffff {
-			os.Exit(0)
+		// Service interrupts and execute instruction
+		_, err := c.Step()
+		if err != nil {
+			f, ok := err.(*Fault)
+			if !ok || !c.DispatchFault(f) {
+				return c.result(HaltFault, c.Cycles-start), err
+			}
 		}
 
-		// Get instruction
-		op := c.Mem.Get(pc)
-		name := dat.OpCodeToName[op>>8]
-		size := dat.OpNameToSize[name]
-		operands := make([]uint16, size)
-		for i := 0; i < size; i++ {
-			operands[i] = c.Mem.Get(pc + uint16(1+i))
+		// Keep to the clock rate
+		if c.ClockRate != 0 {
+			if err := c.pace(ctx, &k, c.Cycles); err != nil {
+				return c.result(HaltCanceled, c.Cycles-start), err
+			}
 		}
+	}
+}
 
-		// Increase program counter
-		c.Regs[dat.RegNamesToNum["pc"]] += uint16(1 + size)
+// result creates a Result from the current state of the CPU.
+func (c *CPU) result(reason HaltReason, cycles uint64) Result {
+	return Result{
+		Reason:   reason,
+		Regs:     c.Regs,
+		Cycles:   cycles,
+		ExitCode: c.exitCode,
+	}
+}
+
+// Halt stops execution with an exit code once the current instruction
+//   has executed.
+func (c *CPU) Halt(code uint16) {
+	c.halted = true
+	c.exitCode = code
+}
 
-		// Execute instruction
-		c.Op(op, operands)
+// Halted returns the exit code passed to Halt, and true if it has been called
+//   since the CPU was booted or its state was restored.
+func (c *CPU) Halted() (uint16, bool) {
+	return c.exitCode, c.halted
+}
+
+// Step services a pending interrupt, then fetches and executes a single
+//   instruction, returning it.
+// It returns a *Fault if the instruction cannot be executed; faults are not
+//   dispatched to the guest fault handler, see DispatchFault.
+// Unlike Run, Step does not block after wfi, see Interrupts.Waiting,
+//   and does not call Poll.
+func (c *CPU) Step() (Instruction, error) {
+	if c.IRQ.waiting && c.IRQ.Pending() != 0 {
+		c.IRQ.waiting = false
 	}
+	if err := c.ServiceInterrupt(); err != nil {
+		return Instruction{}, err
+	}
+
+	c.fetch()
+	c.Cycles++
+	for _, o := range c.observers {
+		o.BeforeExecute(c, c.current)
+	}
+	err := c.execute()
+	for _, o := range c.observers {
+		o.AfterExecute(c, c.current, err)
+	}
+
+	return c.current, err
 }
 
-// Op executes an opcode with the given operands.
-func (c *CPU) Op(packedOpcode uint16, unpackedOperands []uint16) {
+// fetch decodes the instruction at the program counter into c.current,
+//   and moves the program counter past it.
+func (c *CPU) fetch() {
+	pc := c.Regs[dat.PC]
+	word := c.Mem.Get(pc)
+	info := &dat.Ops[word>>8]
+	c.current.PC = pc
+	c.current.Word = word
+	c.current.Info = info
 
 	// Unpack operands
-	opcode := packedOpcode >> 8
-	packed := dat.OpNameToPacked[dat.OpCodeToName[opcode]]
-	numOperands := packed + len(unpackedOperands)
-	operands := make([]uint16, numOperands)
-	switch packed {
+	switch info.Packed {
 	case 1:
-		operands[0] = (packedOpcode << 12) >> 12
+		c.current.Operands[0] = word & 0xf
 	case 2:
-		operands[0] = (packedOpcode << 8) >> 12
-		operands[1] = (packedOpcode << 12) >> 12
+		c.current.Operands[0] = (word >> 4) & 0xf
+		c.current.Operands[1] = word & 0xf
 	}
-	for i, v := range unpackedOperands {
-		operands[i+int(packed)] = v
+	for i := 0; i < info.Size; i++ {
+		c.current.Operands[info.Packed+i] = c.Mem.Get(pc + uint16(1+i))
 	}
 
-	// Operate
-	switch opcode {
-	// nop
-	case 0x00:
-	// cop (reg to copy to, reg to copy from)
-	case 0x01:
-		c.Regs[operands[0]] = c.Regs[operands[1]]
-	// cpl (reg to copy to, value to copy)
-	case 0x02:
-		c.Regs[operands[0]] = operands[1]
-	// str (reg with addr, reg with value)
-	case 0x03:
-		c.Mem.Set(
-			c.Regs[operands[0]],
-			c.Regs[operands[1]],
-		)
-	// ldr (reg to load to, reg with addr)
-	case 0x04:
-		c.Regs[operands[0]] = c.Mem.Get(c.Regs[operands[1]])
-	// add (reg with value)
-	case 0x05:
-		c.Regs[dat.RegNamesToNum["ac"]] = c.Regs[dat.RegNamesToNum["ac"]] + c.Regs[operands[0]]
-	// sub (reg with value)
-	case 0x06:
-		c.Regs[dat.RegNamesToNum["ac"]] = c.Regs[dat.RegNamesToNum["ac"]] + (^c.Regs[operands[0]] + 1)
-	// twc (reg to twc)
-	case 0x07:
-		c.Regs[operands[0]] = ^c.Regs[operands[0]] + 1
-	// inc (reg to inc)
-	case 0x08:
-		c.Regs[operands[0]]++
-	// dec (reg to dec)
-	case 0x09:
-		c.Regs[operands[0]] += (^uint16(1) + 1)
-	// mul (reg with value)
-	case 0x0a:
-		c.Regs[dat.RegNamesToNum["ac"]] *= c.Regs[operands[0]]
-	// div (reg with value)
-	case 0x0b:
-		c.Regs[dat.RegNamesToNum["ex"]] = c.Regs[dat.RegNamesToNum["ac"]] % c.Regs[operands[0]]
-		c.Regs[dat.RegNamesToNum["ac"]] /= c.Regs[operands[0]]
-	// dvc (reg with value)
-	case 0x0c:
-		a := c.Regs[dat.RegNamesToNum["ac"]]
-		b := c.Regs[operands[0]]
-		x, y := a, b
-		aSign, bSign := a>>15, b>>15
-		same := aSign == bSign
-		if aSign == 1 {
-			x = ^x + 1
-		}
-		if bSign == 1 {
-			y = ^y + 1
-		}
-		c.Regs[dat.RegNamesToNum["ex"]] = x % y
-		if same {
-			c.Regs[dat.RegNamesToNum["ac"]] = x / y
-		} else {
-			c.Regs[dat.RegNamesToNum["ac"]] = ^(x / y) + 1
-		}
-	// xor (reg with value)
-	case 0x0d:
-		c.Regs[dat.RegNamesToNum["ac"]] ^= c.Regs[operands[0]]
-	// and (reg with value)
-	case 0x0e:
-		c.Regs[dat.RegNamesToNum["ac"]] &= c.Regs[operands[0]]
-	// orr (reg with value)
-	case 0x0f:
-		c.Regs[dat.RegNamesToNum["ac"]] |= c.Regs[operands[0]]
-	// not (reg to invert)
-	case 0x10:
-		c.Regs[operands[0]] = ^c.Regs[operands[0]]
-	// shr (reg to shift, amount to shift)
-	case 0x11:
-		c.Regs[operands[0]] >>= operands[1]
-	// shl (reg to shift, amount to shift)
-	case 0x12:
-		c.Regs[operands[0]] <<= operands[1]
-	// vga
-	case 0x13:
-		c.VGA.TextDraw()
-	// psh (reg with value)
-	case 0x14:
-		c.Regs[dat.RegNamesToNum["sp"]]--
-		c.Mem.Set(c.Regs[dat.RegNamesToNum["sp"]], c.Regs[operands[0]])
-	// pop (reg to store in)
-	case 0x15:
-		c.Regs[operands[0]] = c.Mem.Get(c.Regs[dat.RegNamesToNum["sp"]])
-		c.Regs[dat.RegNamesToNum["sp"]]++
-	// ret
-	case 0x16:
-		c.Regs[dat.RegNamesToNum["pc"]] = c.Mem.Get(c.Regs[dat.RegNamesToNum["sp"]])
-		c.Regs[dat.RegNamesToNum["sp"]]++
-	// cal (address to jump to)
-	case 0x17:
-		c.Regs[dat.RegNamesToNum["sp"]]--
-		c.Mem.Set(c.Regs[dat.RegNamesToNum["sp"]], c.Regs[dat.RegNamesToNum["pc"]])
-		c.Regs[dat.RegNamesToNum["pc"]] = operands[0]
-	// cmp (register, register)
-	case 0x18:
-		if c.Regs[operands[0]] == c.Regs[operands[1]] {
-			c.Regs[dat.RegNamesToNum["bi"]] = 0xffff
-		} else {
-			c.Regs[dat.RegNamesToNum["bi"]] = 0xfffe
-		}
-	// cle (address to jump to)
-	case 0x19:
-		if c.Regs[dat.RegNamesToNum["bi"]] == 0xffff {
-			c.Regs[dat.RegNamesToNum["sp"]]--
-			c.Mem.Set(c.Regs[dat.RegNamesToNum["sp"]], c.Regs[dat.RegNamesToNum["pc"]])
-			c.Regs[dat.RegNamesToNum["pc"]] = operands[0]
-		}
-	// cln (address to jump to)
-	case 0x1a:
-		if c.Regs[dat.RegNamesToNum["bi"]] == 0xfffe {
-			c.Regs[dat.RegNamesToNum["sp"]]--
-			c.Mem.Set(c.Regs[dat.RegNamesToNum["sp"]], c.Regs[dat.RegNamesToNum["pc"]])
-			c.Regs[dat.RegNamesToNum["pc"]] = operands[0]
-		}
-	// gto (register holding address to jump to)
-	case 0x1b:
-		c.Regs[dat.RegNamesToNum["pc"]] = operands[0]
-	// gte (register holding address to jump to)
-	case 0x1c:
-		if c.Regs[dat.RegNamesToNum["bi"]] == 0xffff {
-			c.Regs[dat.RegNamesToNum["pc"]] = operands[0]
-		}
-	// gtn (register holding address to jump to)
-	case 0x1d:
-		if c.Regs[dat.RegNamesToNum["bi"]] == 0xfffe {
-			c.Regs[dat.RegNamesToNum["pc"]] = operands[0]
-		}
-	// cml (register, value)
-	case 0x1e:
-		if c.Regs[operands[0]] == operands[1] {
-			c.Regs[dat.RegNamesToNum["bi"]] = 0xffff
-		} else {
-			c.Regs[dat.RegNamesToNum["bi"]] = 0xfffe
+	c.Regs[dat.PC] = pc + uint16(1+info.Size)
+}
+
+// execute executes c.current.
+func (c *CPU) execute() error {
+	h := handlers[c.current.Word>>8]
+	if h == nil {
+		return c.fault(FaultInvalidOpcode)
+	}
+
+	// Packed operands are always registers
+	for _, r := range c.current.Operands[:c.current.Info.Packed] {
+		if r >= dat.RegNum {
+			return c.fault(FaultBadRegister)
 		}
 	}
+
+	return h(c, &c.current.Operands)
+}
+
+// fault returns a Fault raised by c.current.
+func (c *CPU) fault(k FaultKind) error {
+	return &Fault{Kind: k, PC: c.current.PC, Opcode: c.current.Word}
 }
@@ -0,0 +1,83 @@
+package cpu
+
+import (
+	"fmt"
+	"github.com/tteeoo/svc/dat"
+	"github.com/tteeoo/svc/mem"
+)
+
+// FaultKind identifies the cause of a Fault.
+type FaultKind uint16
+
+const (
+	// FaultInvalidOpcode is caused by an opcode that does not exist.
+	FaultInvalidOpcode FaultKind = iota + 1
+	// FaultDivideByZero is caused by div or dvc with a zero divisor.
+	FaultDivideByZero
+	// FaultStackOverflow is caused by pushing below the bottom of the stack section.
+	FaultStackOverflow
+	// FaultStackUnderflow is caused by popping above the top of the stack section.
+	FaultStackUnderflow
+	// FaultBadRegister is caused by an operand naming a register that does not exist.
+	FaultBadRegister
+	// FaultBadSyscall is caused by sys with a number that has no syscall registered.
+	FaultBadSyscall
+)
+
+// String returns a human-readable fault kind.
+func (k FaultKind) String() string {
+	switch k {
+	case FaultInvalidOpcode:
+		return "invalid opcode"
+	case FaultDivideByZero:
+		return "division by zero"
+	case FaultStackOverflow:
+		return "stack overflow"
+	case FaultStackUnderflow:
+		return "stack underflow"
+	case FaultBadRegister:
+		return "bad register index"
+	case FaultBadSyscall:
+		return "unknown syscall"
+	}
+	return "unknown fault"
+}
+
+// Fault is an error raised by an instruction that cannot be executed.
+type Fault struct {
+	// Kind is the cause of the fault.
+	Kind FaultKind
+	// PC is the address of the faulting instruction.
+	PC uint16
+	// Opcode is the packed opcode of the faulting instruction.
+	Opcode uint16
+	// Interrupt is true if the fault was raised on entry to the handler
+	//   of interrupt line Line, before the instruction at PC executed.
+	//   Opcode is 0 then.
+	Interrupt bool
+	Line      int
+}
+
+// Error implements the error interface.
+func (f *Fault) Error() string {
+	if f.Interrupt {
+		return fmt.Sprintf("%s entering interrupt %d at %x", f.Kind, f.Line, f.PC)
+	}
+	return fmt.Sprintf("%s at %x (instruction %04x)", f.Kind, f.PC, f.Opcode)
+}
+
+// DispatchFault transfers control to the guest fault handler, whose address
+//   is stored in the system word mem.FaultVectorWord. The faulting PC is pushed onto the stack
+//   and the fault kind is copied into the ex register.
+// It returns false if no handler is set or the stack has no room.
+func (c *CPU) DispatchFault(f *Fault) bool {
+	handler := c.load(c.Mem.System(mem.FaultVectorWord))
+	if handler == 0 || c.Regs[dat.SP] <= c.Mem.StackMin {
+		return false
+	}
+	c.setReg(dat.SP, c.Regs[dat.SP]-1)
+	c.store(c.Regs[dat.SP], f.PC)
+	c.setReg(dat.EX, uint16(f.Kind))
+	c.setReg(dat.PC, handler)
+	return true
+}
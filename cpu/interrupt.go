@@ -0,0 +1,128 @@
+package cpu
+
+import (
+	"context"
+	"github.com/tteeoo/svc/dat"
+	"github.com/tteeoo/svc/mem"
+	"sync/atomic"
+)
+
+// IRQLines is the number of interrupt lines.
+const IRQLines = 16
+
+// Interrupts is an interrupt controller.
+// Raise may be called by devices from any goroutine.
+type Interrupts struct {
+	// Enabled is true if pending interrupts are serviced.
+	Enabled bool
+	// waiting is set by wfi until an interrupt is raised.
+	waiting bool
+	pending uint32
+	wake    chan struct{}
+}
+
+// NewInterrupts returns a pointer to a newly initialized Interrupts.
+func NewInterrupts() *Interrupts {
+	return &Interrupts{
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// Raise marks an interrupt line as pending.
+func (i *Interrupts) Raise(line int) {
+	if line < 0 || line >= IRQLines {
+		return
+	}
+	for {
+		p := atomic.LoadUint32(&i.pending)
+		if atomic.CompareAndSwapUint32(&i.pending, p, p|1<<uint(line)) {
+			break
+		}
+	}
+	i.Wake()
+}
+
+// Wake stops a wait for interrupts after wfi without raising a line,
+//   so Resume calls CPU.Poll again before waiting.
+func (i *Interrupts) Wake() {
+	select {
+	case i.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Pending returns a bitmask of the pending interrupt lines.
+func (i *Interrupts) Pending() uint32 {
+	return atomic.LoadUint32(&i.pending)
+}
+
+// Waiting returns true if wfi was executed and no interrupt has been serviced since.
+func (i *Interrupts) Waiting() bool {
+	return i.waiting
+}
+
+// next returns the lowest pending interrupt line, leaving it pending.
+func (i *Interrupts) next() (int, bool) {
+	p := atomic.LoadUint32(&i.pending)
+	if p == 0 {
+		return 0, false
+	}
+	line := 0
+	for p&(1<<uint(line)) == 0 {
+		line++
+	}
+	return line, true
+}
+
+// clear marks an interrupt line as no longer pending.
+func (i *Interrupts) clear(line int) {
+	for {
+		p := atomic.LoadUint32(&i.pending)
+		if atomic.CompareAndSwapUint32(&i.pending, p, p&^(1<<uint(line))) {
+			return
+		}
+	}
+}
+
+// wait blocks until an interrupt is raised or ctx is canceled.
+func (i *Interrupts) wait(ctx context.Context) error {
+	select {
+	case <-i.wake:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+// ServiceInterrupt transfers control to the handler of the lowest pending
+//   interrupt line, if interrupts are enabled. The vector table starts at
+//   the system word mem.InterruptVectorWord and holds one handler address per line;
+//   interrupts on lines without a handler are dropped.
+// The program counter is pushed onto the stack and interrupts are disabled
+//   until the handler executes rti.
+// If the stack has no room, a stack overflow fault is returned, with
+//   Interrupt set and PC holding the interrupted instruction, which has
+//   not executed. The line stays pending.
+func (c *CPU) ServiceInterrupt() error {
+	if !c.IRQ.Enabled {
+		return nil
+	}
+	line, ok := c.IRQ.next()
+	if !ok {
+		return nil
+	}
+	handler := c.load(c.Mem.System(mem.InterruptVectorWord) + uint16(line))
+	if handler != 0 && c.Regs[dat.SP] <= c.Mem.StackMin {
+		return &Fault{Kind: FaultStackOverflow, PC: c.Regs[dat.PC], Interrupt: true, Line: line}
+	}
+	c.IRQ.clear(line)
+	c.IRQ.waiting = false
+	if handler == 0 {
+		return nil
+	}
+	c.setReg(dat.SP, c.Regs[dat.SP]-1)
+	c.store(c.Regs[dat.SP], c.Regs[dat.PC])
+	c.setReg(dat.PC, handler)
+	c.IRQ.Enabled = false
+	return nil
+}
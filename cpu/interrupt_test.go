@@ -0,0 +1,42 @@
+package cpu
+
+import (
+	"github.com/tteeoo/svc/dat"
+	"github.com/tteeoo/svc/mem"
+	"testing"
+)
+
+// TestServiceInterruptStackFull checks that an interrupt which cannot be
+//   entered because the stack is full stays pending, and that lines without
+//   a handler are still dropped.
+func TestServiceInterruptStackFull(t *testing.T) {
+	m := mem.NewRAMLayout(mem.AddressSpace{}, mem.DefaultLayout())
+	c := NewCPU(mem.NewBus(m), nil)
+	c.Regs[dat.SP] = m.StackMin
+	c.Regs[dat.PC] = 0x1234
+	c.IRQ.Enabled = true
+	c.Mem.Set(m.System(mem.InterruptVectorWord)+2, 0x4000)
+
+	c.IRQ.Raise(2)
+	f, ok := c.ServiceInterrupt().(*Fault)
+	if !ok || f.Kind != FaultStackOverflow || !f.Interrupt || f.Line != 2 || f.PC != 0x1234 {
+		t.Fatalf("ServiceInterrupt returned %v", f)
+	}
+	if c.IRQ.Pending() != 1<<2 || c.Regs[dat.PC] != 0x1234 {
+		t.Errorf("the interrupt was taken: pending %x, pc %x", c.IRQ.Pending(), c.Regs[dat.PC])
+	}
+
+	// Once there is room, it is serviced
+	c.Regs[dat.SP] = m.StackMax
+	if err := c.ServiceInterrupt(); err != nil || c.Regs[dat.PC] != 0x4000 || c.IRQ.Pending() != 0 {
+		t.Errorf("ServiceInterrupt returned %v: pending %x, pc %x", err, c.IRQ.Pending(), c.Regs[dat.PC])
+	}
+
+	// Lines without a handler need no room
+	c.Regs[dat.SP] = m.StackMin
+	c.IRQ.Enabled = true
+	c.IRQ.Raise(3)
+	if err := c.ServiceInterrupt(); err != nil || c.IRQ.Pending() != 0 {
+		t.Errorf("ServiceInterrupt returned %v for a line without a handler: pending %x", err, c.IRQ.Pending())
+	}
+}
@@ -0,0 +1,89 @@
+package cpu
+
+// Observer is notified as the CPU executes instructions.
+// Instruction fetches and the program counter moving past each instruction
+//   are not reported as memory reads or register writes.
+type Observer interface {
+	// BeforeExecute is called after an instruction is fetched, before it executes.
+	BeforeExecute(c *CPU, in Instruction)
+	// AfterExecute is called after an instruction executes, with its error, if any.
+	AfterExecute(c *CPU, in Instruction, err error)
+	// MemoryRead is called when memory is read.
+	MemoryRead(address, value uint16)
+	// MemoryWrite is called when memory is written.
+	MemoryWrite(address, value uint16)
+	// RegisterWrite is called when a register is written.
+	RegisterWrite(register, value uint16)
+}
+
+// NopObserver implements Observer with methods that do nothing.
+// It can be embedded to implement only some methods of Observer.
+type NopObserver struct{}
+
+// BeforeExecute does nothing.
+func (NopObserver) BeforeExecute(c *CPU, in Instruction) {}
+
+// AfterExecute does nothing.
+func (NopObserver) AfterExecute(c *CPU, in Instruction, err error) {}
+
+// MemoryRead does nothing.
+func (NopObserver) MemoryRead(address, value uint16) {}
+
+// MemoryWrite does nothing.
+func (NopObserver) MemoryWrite(address, value uint16) {}
+
+// RegisterWrite does nothing.
+func (NopObserver) RegisterWrite(register, value uint16) {}
+
+// Observe adds an observer to the CPU.
+func (c *CPU) Observe(o Observer) {
+	c.observers = append(c.observers, o)
+}
+
+// Unobserve removes an observer from the CPU.
+// Observers are compared with ==, so pointers should be used.
+func (c *CPU) Unobserve(o Observer) {
+	for i, v := range c.observers {
+		if v == o {
+			c.observers = append(c.observers[:i:i], c.observers[i+1:]...)
+			return
+		}
+	}
+}
+
+// load reads memory, notifying observers.
+func (c *CPU) load(address uint16) uint16 {
+	v := c.Mem.Get(address)
+	for _, o := range c.observers {
+		o.MemoryRead(address, v)
+	}
+	return v
+}
+
+// store writes memory, notifying observers.
+func (c *CPU) store(address, value uint16) {
+	c.Mem.Set(address, value)
+	for _, o := range c.observers {
+		o.MemoryWrite(address, value)
+	}
+}
+
+// setReg writes a register, notifying observers.
+func (c *CPU) setReg(register, value uint16) {
+	c.Regs[register] = value
+	for _, o := range c.observers {
+		o.RegisterWrite(register, value)
+	}
+}
+
+// SetReg writes a register, notifying observers.
+// Syscalls should use it instead of writing to Regs.
+func (c *CPU) SetReg(register, value uint16) {
+	c.setReg(register, value)
+}
+
+// Store writes memory, notifying observers.
+// Syscalls should use it instead of writing to Mem.
+func (c *CPU) Store(address, value uint16) {
+	c.store(address, value)
+}
@@ -0,0 +1,503 @@
+package cpu
+
+import (
+	"github.com/tteeoo/svc/dat"
+)
+
+// MaxOperands is the largest number of operands an instruction can have.
+const MaxOperands = 4
+
+// handler executes an instruction with the given operands.
+type handler func(c *CPU, o *[MaxOperands]uint16) error
+
+// handlers maps opcode prefixes to handlers.
+// It is created from opHandlers at runtime.
+var handlers [256]handler
+
+func init() {
+	for name, h := range opHandlers {
+		handlers[dat.OpNameToCode[name]] = h
+	}
+}
+
+// opHandlers maps instruction names to handlers.
+var opHandlers = map[string]handler{
+	// nop
+	"nop": func(c *CPU, o *[MaxOperands]uint16) error {
+		return nil
+	},
+	// cop (reg to copy to, reg to copy from)
+	"cop": func(c *CPU, o *[MaxOperands]uint16) error {
+		c.setReg(o[0], c.Regs[o[1]])
+		return nil
+	},
+	// cpl (reg to copy to, value to copy)
+	"cpl": func(c *CPU, o *[MaxOperands]uint16) error {
+		c.setReg(o[0], o[1])
+		return nil
+	},
+	// str (reg with addr, reg with value)
+	"str": func(c *CPU, o *[MaxOperands]uint16) error {
+		c.store(c.Regs[o[0]], c.Regs[o[1]])
+		return nil
+	},
+	// ldr (reg to load to, reg with addr)
+	"ldr": func(c *CPU, o *[MaxOperands]uint16) error {
+		c.setReg(o[0], c.load(c.Regs[o[1]]))
+		return nil
+	},
+	// add (reg with value)
+	"add": func(c *CPU, o *[MaxOperands]uint16) error {
+		r, f := add(c.Regs[dat.AC], c.Regs[o[0]], 0)
+		c.setReg(dat.FL, f)
+		c.setReg(dat.AC, r)
+		return nil
+	},
+	// sub (reg with value)
+	"sub": func(c *CPU, o *[MaxOperands]uint16) error {
+		r, f := subtract(c.Regs[dat.AC], c.Regs[o[0]], 0)
+		c.setReg(dat.FL, f)
+		c.setReg(dat.AC, r)
+		return nil
+	},
+	// twc (reg to twc)
+	"twc": func(c *CPU, o *[MaxOperands]uint16) error {
+		r, f := subtract(0, c.Regs[o[0]], 0)
+		c.setReg(dat.FL, f)
+		c.setReg(o[0], r)
+		return nil
+	},
+	// inc (reg to inc)
+	"inc": func(c *CPU, o *[MaxOperands]uint16) error {
+		r, f := add(c.Regs[o[0]], 1, 0)
+		c.setReg(dat.FL, keepCarry(c.Regs[dat.FL], f))
+		c.setReg(o[0], r)
+		return nil
+	},
+	// dec (reg to dec)
+	"dec": func(c *CPU, o *[MaxOperands]uint16) error {
+		r, f := subtract(c.Regs[o[0]], 1, 0)
+		c.setReg(dat.FL, keepCarry(c.Regs[dat.FL], f))
+		c.setReg(o[0], r)
+		return nil
+	},
+	// mul (reg with value)
+	"mul": func(c *CPU, o *[MaxOperands]uint16) error {
+		p := uint32(c.Regs[dat.AC]) * uint32(c.Regs[o[0]])
+		f := resultFlags(uint16(p))
+		if p > 0xffff {
+			f |= dat.FlagCarry | dat.FlagOverflow
+		}
+		c.setReg(dat.FL, f)
+		c.setReg(dat.AC, uint16(p))
+		return nil
+	},
+	// div (reg with value)
+	"div": func(c *CPU, o *[MaxOperands]uint16) error {
+		b := c.Regs[o[0]]
+		if b == 0 {
+			return c.fault(FaultDivideByZero)
+		}
+		c.setReg(dat.EX, c.Regs[dat.AC]%b)
+		c.setReg(dat.AC, c.Regs[dat.AC]/b)
+		return nil
+	},
+	// dvc (reg with value)
+	"dvc": func(c *CPU, o *[MaxOperands]uint16) error {
+		a := c.Regs[dat.AC]
+		b := c.Regs[o[0]]
+		if b == 0 {
+			return c.fault(FaultDivideByZero)
+		}
+		x, y := a, b
+		aSign, bSign := a>>15, b>>15
+		same := aSign == bSign
+		if aSign == 1 {
+			x = ^x + 1
+		}
+		if bSign == 1 {
+			y = ^y + 1
+		}
+		c.setReg(dat.EX, x%y)
+		if same {
+			c.setReg(dat.AC, x/y)
+		} else {
+			c.setReg(dat.AC, ^(x/y)+1)
+		}
+		return nil
+	},
+	// xor (reg with value)
+	"xor": func(c *CPU, o *[MaxOperands]uint16) error {
+		c.setReg(dat.AC, c.Regs[dat.AC]^c.Regs[o[0]])
+		return nil
+	},
+	// and (reg with value)
+	"and": func(c *CPU, o *[MaxOperands]uint16) error {
+		c.setReg(dat.AC, c.Regs[dat.AC]&c.Regs[o[0]])
+		return nil
+	},
+	// orr (reg with value)
+	"orr": func(c *CPU, o *[MaxOperands]uint16) error {
+		c.setReg(dat.AC, c.Regs[dat.AC]|c.Regs[o[0]])
+		return nil
+	},
+	// not (reg to invert)
+	"not": func(c *CPU, o *[MaxOperands]uint16) error {
+		c.setReg(o[0], ^c.Regs[o[0]])
+		return nil
+	},
+	// shr (reg to shift, amount to shift)
+	"shr": func(c *CPU, o *[MaxOperands]uint16) error {
+		c.setReg(o[0], c.Regs[o[0]]>>o[1])
+		return nil
+	},
+	// shl (reg to shift, amount to shift)
+	"shl": func(c *CPU, o *[MaxOperands]uint16) error {
+		c.setReg(o[0], c.Regs[o[0]]<<o[1])
+		return nil
+	},
+	// vga
+	"vga": func(c *CPU, o *[MaxOperands]uint16) error {
+		if c.VGA != nil {
+			c.VGA.TextDraw()
+		}
+		return nil
+	},
+	// psh (reg with value)
+	"psh": func(c *CPU, o *[MaxOperands]uint16) error {
+		return c.push(c.Regs[o[0]])
+	},
+	// pop (reg to store in)
+	"pop": func(c *CPU, o *[MaxOperands]uint16) error {
+		v, err := c.pop()
+		if err != nil {
+			return err
+		}
+		c.setReg(o[0], v)
+		return nil
+	},
+	// ret
+	"ret": func(c *CPU, o *[MaxOperands]uint16) error {
+		v, err := c.pop()
+		if err != nil {
+			return err
+		}
+		c.setReg(dat.PC, v)
+		return nil
+	},
+	// cal (address to jump to)
+	"cal": func(c *CPU, o *[MaxOperands]uint16) error {
+		return c.call(o[0])
+	},
+	// cmp (register, register)
+	"cmp": func(c *CPU, o *[MaxOperands]uint16) error {
+		c.compare(c.Regs[o[0]], c.Regs[o[1]])
+		return nil
+	},
+	// cle (address to jump to)
+	"cle": func(c *CPU, o *[MaxOperands]uint16) error {
+		if c.Regs[dat.BI] == 0xffff {
+			return c.call(o[0])
+		}
+		return nil
+	},
+	// cln (address to jump to)
+	"cln": func(c *CPU, o *[MaxOperands]uint16) error {
+		if c.Regs[dat.BI] == 0xfffe {
+			return c.call(o[0])
+		}
+		return nil
+	},
+	// gto (address to jump to)
+	"gto": func(c *CPU, o *[MaxOperands]uint16) error {
+		c.setReg(dat.PC, o[0])
+		return nil
+	},
+	// gte (address to jump to)
+	"gte": func(c *CPU, o *[MaxOperands]uint16) error {
+		if c.Regs[dat.BI] == 0xffff {
+			c.setReg(dat.PC, o[0])
+		}
+		return nil
+	},
+	// gtn (address to jump to)
+	"gtn": func(c *CPU, o *[MaxOperands]uint16) error {
+		if c.Regs[dat.BI] == 0xfffe {
+			c.setReg(dat.PC, o[0])
+		}
+		return nil
+	},
+	// cml (register, value)
+	"cml": func(c *CPU, o *[MaxOperands]uint16) error {
+		c.compare(c.Regs[o[0]], o[1])
+		return nil
+	},
+	// eni
+	"eni": func(c *CPU, o *[MaxOperands]uint16) error {
+		c.IRQ.Enabled = true
+		return nil
+	},
+	// dsi
+	"dsi": func(c *CPU, o *[MaxOperands]uint16) error {
+		c.IRQ.Enabled = false
+		return nil
+	},
+	// rti
+	"rti": func(c *CPU, o *[MaxOperands]uint16) error {
+		v, err := c.pop()
+		if err != nil {
+			return err
+		}
+		c.setReg(dat.PC, v)
+		c.IRQ.Enabled = true
+		return nil
+	},
+	// wfi
+	"wfi": func(c *CPU, o *[MaxOperands]uint16) error {
+		c.IRQ.waiting = true
+		return nil
+	},
+	// adc (reg with value)
+	"adc": func(c *CPU, o *[MaxOperands]uint16) error {
+		r, f := add(c.Regs[dat.AC], c.Regs[o[0]], c.Regs[dat.FL]&dat.FlagCarry)
+		c.setReg(dat.FL, f)
+		c.setReg(dat.AC, r)
+		return nil
+	},
+	// sbb (reg with value)
+	"sbb": func(c *CPU, o *[MaxOperands]uint16) error {
+		r, f := subtract(c.Regs[dat.AC], c.Regs[o[0]], c.Regs[dat.FL]&dat.FlagCarry)
+		c.setReg(dat.FL, f)
+		c.setReg(dat.AC, r)
+		return nil
+	},
+	// glt, ggt, gbl, gab, gcs (address to jump to)
+	"glt": jumpIf(less),
+	"ggt": jumpIf(greater),
+	"gbl": jumpIf(below),
+	"gab": jumpIf(above),
+	"gcs": jumpIf(carry),
+	// clt, cgt, cbl, cab, ccs (address to jump to)
+	"clt": callIf(less),
+	"cgt": callIf(greater),
+	"cbl": callIf(below),
+	"cab": callIf(above),
+	"ccs": callIf(carry),
+	// gtr (reg holding address to jump to)
+	"gtr": func(c *CPU, o *[MaxOperands]uint16) error {
+		c.setReg(dat.PC, c.Regs[o[0]])
+		return nil
+	},
+	// ger (reg holding address to jump to)
+	"ger": func(c *CPU, o *[MaxOperands]uint16) error {
+		if c.Regs[dat.BI] == 0xffff {
+			c.setReg(dat.PC, c.Regs[o[0]])
+		}
+		return nil
+	},
+	// gnr (reg holding address to jump to)
+	"gnr": func(c *CPU, o *[MaxOperands]uint16) error {
+		if c.Regs[dat.BI] == 0xfffe {
+			c.setReg(dat.PC, c.Regs[o[0]])
+		}
+		return nil
+	},
+	// clr (reg holding address to call)
+	"clr": func(c *CPU, o *[MaxOperands]uint16) error {
+		return c.call(c.Regs[o[0]])
+	},
+	// cer (reg holding address to call)
+	"cer": func(c *CPU, o *[MaxOperands]uint16) error {
+		if c.Regs[dat.BI] == 0xffff {
+			return c.call(c.Regs[o[0]])
+		}
+		return nil
+	},
+	// cnr (reg holding address to call)
+	"cnr": func(c *CPU, o *[MaxOperands]uint16) error {
+		if c.Regs[dat.BI] == 0xfffe {
+			return c.call(c.Regs[o[0]])
+		}
+		return nil
+	},
+	// grl (offset to jump by)
+	"grl": func(c *CPU, o *[MaxOperands]uint16) error {
+		c.setReg(dat.PC, c.Regs[dat.PC]+o[0])
+		return nil
+	},
+	// gel (offset to jump by)
+	"gel": func(c *CPU, o *[MaxOperands]uint16) error {
+		if c.Regs[dat.BI] == 0xffff {
+			c.setReg(dat.PC, c.Regs[dat.PC]+o[0])
+		}
+		return nil
+	},
+	// gnl (offset to jump by)
+	"gnl": func(c *CPU, o *[MaxOperands]uint16) error {
+		if c.Regs[dat.BI] == 0xfffe {
+			c.setReg(dat.PC, c.Regs[dat.PC]+o[0])
+		}
+		return nil
+	},
+	// crl (offset to call)
+	"crl": func(c *CPU, o *[MaxOperands]uint16) error {
+		return c.call(c.Regs[dat.PC] + o[0])
+	},
+	// cel (offset to call)
+	"cel": func(c *CPU, o *[MaxOperands]uint16) error {
+		if c.Regs[dat.BI] == 0xffff {
+			return c.call(c.Regs[dat.PC] + o[0])
+		}
+		return nil
+	},
+	// cnl (offset to call)
+	"cnl": func(c *CPU, o *[MaxOperands]uint16) error {
+		if c.Regs[dat.BI] == 0xfffe {
+			return c.call(c.Regs[dat.PC] + o[0])
+		}
+		return nil
+	},
+	// sys (number of syscall)
+	"sys": func(c *CPU, o *[MaxOperands]uint16) error {
+		s, exists := c.Syscalls[o[0]]
+		if !exists {
+			return c.fault(FaultBadSyscall)
+		}
+		return s(c)
+	},
+	// hlt (reg with exit code)
+	"hlt": func(c *CPU, o *[MaxOperands]uint16) error {
+		c.Halt(c.Regs[o[0]])
+		return nil
+	},
+}
+
+// jumpIf returns a handler that jumps to an address if cond holds for the flags.
+func jumpIf(cond func(flags uint16) bool) handler {
+	return func(c *CPU, o *[MaxOperands]uint16) error {
+		if cond(c.Regs[dat.FL]) {
+			c.setReg(dat.PC, o[0])
+		}
+		return nil
+	}
+}
+
+// callIf returns a handler that calls an address if cond holds for the flags.
+func callIf(cond func(flags uint16) bool) handler {
+	return func(c *CPU, o *[MaxOperands]uint16) error {
+		if cond(c.Regs[dat.FL]) {
+			return c.call(o[0])
+		}
+		return nil
+	}
+}
+
+// less returns true if the last comparison was signed less than.
+func less(flags uint16) bool {
+	return (flags&dat.FlagSign != 0) != (flags&dat.FlagOverflow != 0)
+}
+
+// greater returns true if the last comparison was signed greater than.
+func greater(flags uint16) bool {
+	return flags&dat.FlagZero == 0 && !less(flags)
+}
+
+// below returns true if the last comparison was unsigned less than.
+func below(flags uint16) bool {
+	return flags&dat.FlagCarry != 0
+}
+
+// above returns true if the last comparison was unsigned greater than.
+func above(flags uint16) bool {
+	return flags&(dat.FlagCarry|dat.FlagZero) == 0
+}
+
+// carry returns true if the carry flag is set.
+func carry(flags uint16) bool {
+	return flags&dat.FlagCarry != 0
+}
+
+// add returns a+b+carryIn and the flags it sets.
+func add(a, b, carryIn uint16) (uint16, uint16) {
+	sum := uint32(a) + uint32(b) + uint32(carryIn)
+	r := uint16(sum)
+	f := resultFlags(r)
+	if sum > 0xffff {
+		f |= dat.FlagCarry
+	}
+	if (a^r)&(b^r)&0x8000 != 0 {
+		f |= dat.FlagOverflow
+	}
+	return r, f
+}
+
+// subtract returns a-b-borrow and the flags it sets.
+func subtract(a, b, borrow uint16) (uint16, uint16) {
+	r := a - b - borrow
+	f := resultFlags(r)
+	if uint32(a) < uint32(b)+uint32(borrow) {
+		f |= dat.FlagCarry
+	}
+	if (a^b)&(a^r)&0x8000 != 0 {
+		f |= dat.FlagOverflow
+	}
+	return r, f
+}
+
+// resultFlags returns the sign and zero flags for a result.
+func resultFlags(r uint16) uint16 {
+	var f uint16
+	if r&0x8000 != 0 {
+		f |= dat.FlagSign
+	}
+	if r == 0 {
+		f |= dat.FlagZero
+	}
+	return f
+}
+
+// keepCarry returns flags with the carry flag taken from old.
+func keepCarry(old, flags uint16) uint16 {
+	return flags&^dat.FlagCarry | old&dat.FlagCarry
+}
+
+// push pushes a value onto the stack.
+func (c *CPU) push(v uint16) error {
+	if c.Regs[dat.SP] <= c.Mem.StackMin {
+		return c.fault(FaultStackOverflow)
+	}
+	c.setReg(dat.SP, c.Regs[dat.SP]-1)
+	c.store(c.Regs[dat.SP], v)
+	return nil
+}
+
+// pop pops a value off of the stack.
+func (c *CPU) pop() (uint16, error) {
+	if c.Regs[dat.SP] > c.Mem.StackMax {
+		return 0, c.fault(FaultStackUnderflow)
+	}
+	v := c.load(c.Regs[dat.SP])
+	c.setReg(dat.SP, c.Regs[dat.SP]+1)
+	return v, nil
+}
+
+// call pushes the program counter and jumps to an address.
+func (c *CPU) call(address uint16) error {
+	if err := c.push(c.Regs[dat.PC]); err != nil {
+		return err
+	}
+	c.setReg(dat.PC, address)
+	return nil
+}
+
+// compare sets the boolean index to 0xffff if two values are equal, else 0xfffe,
+//   and sets the flags as if b was subtracted from a.
+func (c *CPU) compare(a, b uint16) {
+	_, f := subtract(a, b, 0)
+	c.setReg(dat.FL, f)
+	if a == b {
+		c.setReg(dat.BI, 0xffff)
+	} else {
+		c.setReg(dat.BI, 0xfffe)
+	}
+}
@@ -0,0 +1,95 @@
+package cpu
+
+import (
+	"fmt"
+	"github.com/tteeoo/svc/dat"
+	"github.com/tteeoo/svc/mem"
+	"strings"
+	"testing"
+)
+
+const (
+	fc = dat.FlagCarry
+	fo = dat.FlagOverflow
+	fs = dat.FlagSign
+	fz = dat.FlagZero
+)
+
+// jumps are the conditional jumps checked after each operation.
+var jumps = []string{"glt", "ggt", "gbl", "gab", "gcs", "gte", "gtn"}
+
+// TestFlags checks the flags set by the arithmetic and comparison
+//   instructions at signed and unsigned boundaries, and which conditional
+//   jumps are taken after them.
+func TestFlags(t *testing.T) {
+	tests := []struct {
+		op      string
+		a, b    uint16
+		carryIn uint16
+		result  uint16
+		fl      uint16
+		taken   string
+	}{
+		// Signed overflow and unsigned carry
+		{"add", 0x7fff, 1, 0, 0x8000, fs | fo, "ggt gab"},
+		{"add", 0xffff, 1, 0, 0, fz | fc, "gbl gcs"},
+		{"add", 0x8000, 0x8000, 0, 0, fz | fc | fo, "glt gbl gcs"},
+		{"add", 1, 2, 0, 3, 0, "ggt gab"},
+		{"sub", 0x8000, 1, 0, 0x7fff, fo, "glt gab"},
+		{"sub", 0, 1, 0, 0xffff, fs | fc, "glt gbl gcs"},
+		{"sub", 5, 5, 0, 0, fz, ""},
+
+		// The carry flag as an input
+		{"adc", 0xffff, 0, fc, 0, fz | fc, "gbl gcs"},
+		{"adc", 0x7ffe, 1, fc, 0x8000, fs | fo, "ggt gab"},
+		{"adc", 1, 1, 0, 2, 0, "ggt gab"},
+		{"sbb", 0, 0, fc, 0xffff, fs | fc, "glt gbl gcs"},
+		{"sbb", 0x8000, 0, fc, 0x7fff, fo, "glt gab"},
+		{"sbb", 5, 5, 0, 0, fz, ""},
+		{"sbb", 5, 4, fc, 0, fz, ""},
+
+		// Comparisons, which also set bi for gte and gtn
+		{"cmp", 1, 2, 0, 1, fs | fc, "glt gbl gcs gtn"},
+		{"cmp", 0x8000, 1, 0, 0x8000, fo, "glt gab gtn"},
+		{"cmp", 0x7fff, 0xffff, 0, 0x7fff, fs | fo | fc, "ggt gbl gcs gtn"},
+		{"cmp", 2, 2, 0, 2, fz, "gte"},
+		{"cml", 0xffff, 0x7fff, 0, 0xffff, fs, "glt gab gtn"},
+		{"cml", 3, 3, fc, 3, fz, "gte"},
+	}
+	for _, test := range tests {
+		c := NewCPU(mem.NewBus(mem.NewRAMLayout(mem.AddressSpace{}, mem.DefaultLayout())), nil)
+		c.Regs[dat.AC] = test.a
+		c.Regs[dat.RB] = test.b
+		c.Regs[dat.FL] = test.carryIn
+		o := [MaxOperands]uint16{dat.RB}
+		switch test.op {
+		case "cmp":
+			o = [MaxOperands]uint16{dat.AC, dat.RB}
+		case "cml":
+			o = [MaxOperands]uint16{dat.AC, test.b}
+		}
+		if err := opHandlers[test.op](c, &o); err != nil {
+			t.Fatal(err)
+		}
+		name := fmt.Sprintf("%s %04x %04x", test.op, test.a, test.b)
+		if c.Regs[dat.AC] != test.result || c.Regs[dat.FL] != test.fl {
+			t.Errorf("%s: ac=%04x fl=%x, want ac=%04x fl=%x", name, c.Regs[dat.AC], c.Regs[dat.FL], test.result, test.fl)
+		}
+
+		// Try each jump with the flags
+		taken := []string{}
+		for _, j := range jumps {
+			c.Regs[dat.PC] = 0
+			o := [MaxOperands]uint16{0x1000}
+			if err := opHandlers[j](c, &o); err != nil {
+				t.Fatal(err)
+			}
+			if c.Regs[dat.PC] == 0x1000 {
+				taken = append(taken, j)
+			}
+		}
+		if got := strings.Join(taken, " "); got != test.taken {
+			t.Errorf("%s: took %q, want %q", name, got, test.taken)
+		}
+	}
+}
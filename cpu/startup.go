@@ -0,0 +1,86 @@
+package cpu
+
+import (
+	"fmt"
+	"github.com/tteeoo/svc/dat"
+	"github.com/tteeoo/svc/mem"
+)
+
+// Startup describes the state a program is started with.
+type Startup struct {
+	// Args are the program arguments, loaded into the heap.
+	Args []string
+	// Env are environment entries in "key=value" form,
+	//   loaded into the heap after the arguments.
+	Env []string
+	// Stack holds values pushed onto the stack before the exit address,
+	//   from the bottom of the stack to the top.
+	Stack []uint16
+}
+
+// Boot prepares memory and registers to start execution at the given
+//   memory address with the given startup state.
+// It returns an error, leaving memory and registers as they were, if the
+//   arguments and environment do not fit in the heap, or the initial stack
+//   contents and exit address do not fit in the stack.
+func (c *CPU) Boot(address uint16, s Startup) error {
+
+	// Check that the startup state fits
+	heapSize := int(c.Mem.SystemOffset) - int(c.Mem.HeapOffset)
+	if n := stringsSize(s.Args) + stringsSize(s.Env); n > heapSize {
+		return fmt.Errorf("the arguments and environment take %d words, but the heap only holds %d", n, heapSize)
+	}
+	stackSize := int(c.Mem.StackMax) - int(c.Mem.StackMin) + 1
+	if n := len(s.Stack) + 1; n > stackSize {
+		return fmt.Errorf("the initial stack takes %d words with the exit address, but the stack only holds %d", n, stackSize)
+	}
+
+	// Put args and environment into heap
+	i := c.Mem.HeapOffset
+	i = c.loadStrings(i, s.Args)
+	c.Mem.Set(c.Mem.System(mem.ArgSizeWord), i-c.Mem.HeapOffset)
+	c.Mem.Set(c.Mem.System(mem.ArgCountWord), uint16(len(s.Args)))
+	envStart := i
+	i = c.loadStrings(i, s.Env)
+	c.Mem.Set(c.Mem.System(mem.EnvSizeWord), i-envStart)
+	c.Mem.Set(c.Mem.System(mem.EnvCountWord), uint16(len(s.Env)))
+	c.Mem.Set(c.Mem.System(mem.HeapWord), c.Mem.HeapOffset)
+
+	// Push initial stack contents, then the exit address
+	c.Regs[dat.SP] = c.Mem.StackMax
+	for _, v := range s.Stack {
+		c.Mem.Set(c.Regs[dat.SP], v)
+		c.Regs[dat.SP]--
+	}
+	c.Mem.Set(c.Regs[dat.SP], 0xffff)
+
+	// Set the program counter
+	c.Regs[dat.PC] = address
+	c.halted = false
+	c.exitCode = 0
+	c.Cycles = 0
+	return nil
+}
+
+// stringsSize returns the number of words loadStrings stores strings in.
+func stringsSize(strs []string) int {
+	n := 0
+	for _, str := range strs {
+		n += len([]rune(str)) + 1
+	}
+	return n
+}
+
+// loadStrings stores null-terminated strings in memory starting
+//   at the given address, returning the address after the last one.
+func (c *CPU) loadStrings(address uint16, strs []string) uint16 {
+	for _, str := range strs {
+		for _, char := range str {
+			c.Mem.Set(address, uint16(char))
+			address++
+		}
+		c.Mem.Set(address, 0)
+		address++
+	}
+	return address
+}
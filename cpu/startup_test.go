@@ -0,0 +1,43 @@
+package cpu
+
+import (
+	"github.com/tteeoo/svc/dat"
+	"github.com/tteeoo/svc/mem"
+	"strings"
+	"testing"
+)
+
+// TestBootLimits checks that Boot refuses startup state that does not fit
+//   in the heap or the stack, and accepts state that just fits.
+func TestBootLimits(t *testing.T) {
+	m := mem.NewRAMLayout(mem.AddressSpace{}, mem.DefaultLayout())
+	heapSize := int(m.SystemOffset - m.HeapOffset)
+	stackSize := int(m.StackMax - m.StackMin + 1)
+
+	tests := []struct {
+		name string
+		s    Startup
+		ok   bool
+	}{
+		{"heap full", Startup{Args: []string{strings.Repeat("a", heapSize-2)}, Env: []string{""}}, true},
+		{"heap overflow", Startup{Args: []string{strings.Repeat("a", heapSize-2)}, Env: []string{"b"}}, false},
+		{"stack full", Startup{Stack: make([]uint16, stackSize-1)}, true},
+		{"stack overflow", Startup{Stack: make([]uint16, stackSize)}, false},
+	}
+	for _, test := range tests {
+		m := mem.NewRAMLayout(mem.AddressSpace{}, mem.DefaultLayout())
+		c := NewCPU(mem.NewBus(m), nil)
+		before := m.Mem
+		err := c.Boot(m.ProgramOffset, test.s)
+		if test.ok && err != nil {
+			t.Errorf("%s: Boot returned %v", test.name, err)
+		}
+		if !test.ok {
+			if err == nil {
+				t.Errorf("%s: Boot returned no error", test.name)
+			} else if m.Mem != before || c.Regs[dat.PC] != 0 {
+				t.Errorf("%s: Boot changed the machine before returning an error", test.name)
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package cpu
+
+import (
+	"github.com/tteeoo/svc/dat"
+	"sync/atomic"
+)
+
+// State is the state of a CPU, not including its memory and devices.
+type State struct {
+	Regs     [dat.RegNum]uint16
+	Cycles   uint64
+	Halted   bool
+	ExitCode uint16
+	IRQ      InterruptState
+}
+
+// InterruptState is the state of an interrupt controller.
+type InterruptState struct {
+	Enabled bool
+	Waiting bool
+	Pending uint32
+}
+
+// State returns the state of the CPU.
+func (c *CPU) State() State {
+	return State{
+		Regs:     c.Regs,
+		Cycles:   c.Cycles,
+		Halted:   c.halted,
+		ExitCode: c.exitCode,
+		IRQ:      c.IRQ.State(),
+	}
+}
+
+// SetState restores the state of the CPU.
+func (c *CPU) SetState(s State) {
+	c.Regs = s.Regs
+	c.Cycles = s.Cycles
+	c.halted = s.Halted
+	c.exitCode = s.ExitCode
+	c.IRQ.SetState(s.IRQ)
+}
+
+// State returns the state of the interrupt controller.
+func (i *Interrupts) State() InterruptState {
+	return InterruptState{
+		Enabled: i.Enabled,
+		Waiting: i.waiting,
+		Pending: i.Pending(),
+	}
+}
+
+// SetState restores the state of the interrupt controller.
+func (i *Interrupts) SetState(s InterruptState) {
+	i.Enabled = s.Enabled
+	i.waiting = s.Waiting
+	atomic.StoreUint32(&i.pending, s.Pending)
+	if s.Pending != 0 {
+		select {
+		case i.wake <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package cpu
+
+import (
+	"github.com/tteeoo/svc/dat"
+	"io"
+	"math/rand"
+	"time"
+)
+
+// Syscall is a host function that can be called by the sys instruction.
+// Arguments are passed in the general purpose registers, starting with ra,
+//   and results are returned in ac and ex.
+// A returned error stops execution.
+type Syscall func(c *CPU) error
+
+// Numbers of the default syscalls.
+const (
+	// SysWrite writes rb words, starting at the address in ra, to Stdout,
+	//   one byte per word. ac is set to the number of words written,
+	//   and ex to 1 if there was an error, else 0.
+	SysWrite uint16 = iota
+	// SysRead reads up to rb bytes from Stdin into memory starting at the
+	//   address in ra, one byte per word. ac is set to the number of words
+	//   read (0 at the end of input), and ex to 1 if there was an error, else 0.
+	SysRead
+	// SysExit halts the CPU with the exit code in ra.
+	SysExit
+	// SysTime sets ac to the low word and ex to the high word of the
+	//   current Unix time in seconds.
+	SysTime
+	// SysRandom sets ac to a random word.
+	SysRandom
+)
+
+// DefaultSyscalls returns a new registry holding the default syscalls.
+func DefaultSyscalls() map[uint16]Syscall {
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return map[uint16]Syscall{
+		SysWrite: func(c *CPU) error {
+			buf := make([]byte, c.Regs[dat.RB])
+			for i := range buf {
+				buf[i] = byte(c.load(c.Regs[dat.RA] + uint16(i)))
+			}
+			n, err := c.Stdout.Write(buf)
+			c.setReg(dat.AC, uint16(n))
+			c.setReg(dat.EX, errorWord(err))
+			return nil
+		},
+		SysRead: func(c *CPU) error {
+			buf := make([]byte, c.Regs[dat.RB])
+			n, err := c.Stdin.Read(buf)
+			for i, b := range buf[:n] {
+				c.store(c.Regs[dat.RA]+uint16(i), uint16(b))
+			}
+			c.setReg(dat.AC, uint16(n))
+			if n > 0 {
+				err = nil
+			}
+			c.setReg(dat.EX, errorWord(err))
+			return nil
+		},
+		SysExit: func(c *CPU) error {
+			c.Halt(c.Regs[dat.RA])
+			return nil
+		},
+		SysTime: func(c *CPU) error {
+			now := uint32(time.Now().Unix())
+			c.setReg(dat.AC, uint16(now))
+			c.setReg(dat.EX, uint16(now>>16))
+			return nil
+		},
+		SysRandom: func(c *CPU) error {
+			c.setReg(dat.AC, uint16(random.Uint32()))
+			return nil
+		},
+	}
+}
+
+// RegisterSyscall adds a syscall to the registry, replacing any syscall
+//   with the same number.
+func (c *CPU) RegisterSyscall(number uint16, s Syscall) {
+	c.Syscalls[number] = s
+}
+
+// errorWord returns 0 if err is nil or io.EOF, else 1.
+func errorWord(err error) uint16 {
+	if err == nil || err == io.EOF {
+		return 0
+	}
+	return 1
+}
@@ -2,8 +2,18 @@
 package dat
 
 func init() {
-	// Create the CodeToName map.
+	// Create the CodeToName map and the Ops table.
 	for k, v := range OpNameToCode {
 		OpCodeToName[v] = k
+		Ops[v] = OpInfo{
+			Name:   k,
+			Packed: OpNameToPacked[k],
+			Size:   OpNameToSize[k],
+		}
+	}
+
+	// Create the RegNumToName table.
+	for k, v := range RegNamesToNum {
+		RegNumToName[v] = k
 	}
 }
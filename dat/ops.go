@@ -1,6 +1,20 @@
 package dat
 
+// OpInfo describes how an instruction is encoded.
+type OpInfo struct {
+	// Name is the name of the instruction, or "" if the opcode does not exist.
+	Name string
+	// Packed is the number of operands packed into the opcode word.
+	Packed int
+	// Size is the number of extra operands following the opcode word.
+	Size int
+}
+
 var (
+	// Ops maps opcode prefixes to instruction information.
+	// It is created from the maps below at runtime.
+	Ops [256]OpInfo
+
 	// OpNameToCode maps names to opcode prefixes.
 	OpNameToCode = map[string]uint16{
 		"nop": 0x00,
@@ -34,6 +48,36 @@ var (
 		"gte": 0x1c,
 		"gtn": 0x1d,
 		"cml": 0x1e,
+		"eni": 0x1f,
+		"dsi": 0x20,
+		"rti": 0x21,
+		"wfi": 0x22,
+		"adc": 0x23,
+		"sbb": 0x24,
+		"glt": 0x25,
+		"ggt": 0x26,
+		"gbl": 0x27,
+		"gab": 0x28,
+		"gcs": 0x29,
+		"clt": 0x2a,
+		"cgt": 0x2b,
+		"cbl": 0x2c,
+		"cab": 0x2d,
+		"ccs": 0x2e,
+		"gtr": 0x2f,
+		"ger": 0x30,
+		"gnr": 0x31,
+		"clr": 0x32,
+		"cer": 0x33,
+		"cnr": 0x34,
+		"grl": 0x35,
+		"gel": 0x36,
+		"gnl": 0x37,
+		"crl": 0x38,
+		"cel": 0x39,
+		"cnl": 0x3a,
+		"sys": 0x3b,
+		"hlt": 0x3c,
 	}
 
 	// OpCodeToName is the reverse of OpNameToCode.
@@ -73,6 +117,36 @@ var (
 		"gte": 0,
 		"gtn": 0,
 		"cml": 1,
+		"eni": 0,
+		"dsi": 0,
+		"rti": 0,
+		"wfi": 0,
+		"adc": 1,
+		"sbb": 1,
+		"glt": 0,
+		"ggt": 0,
+		"gbl": 0,
+		"gab": 0,
+		"gcs": 0,
+		"clt": 0,
+		"cgt": 0,
+		"cbl": 0,
+		"cab": 0,
+		"ccs": 0,
+		"gtr": 1,
+		"ger": 1,
+		"gnr": 1,
+		"clr": 1,
+		"cer": 1,
+		"cnr": 1,
+		"grl": 0,
+		"gel": 0,
+		"gnl": 0,
+		"crl": 0,
+		"cel": 0,
+		"cnl": 0,
+		"sys": 0,
+		"hlt": 1,
 	}
 
 	// OpNameToSize maps instruction names to the number of extra operands it had.
@@ -108,5 +182,46 @@ var (
 		"gte": 1,
 		"gtn": 1,
 		"cml": 1,
+		"eni": 0,
+		"dsi": 0,
+		"rti": 0,
+		"wfi": 0,
+		"adc": 0,
+		"sbb": 0,
+		"glt": 1,
+		"ggt": 1,
+		"gbl": 1,
+		"gab": 1,
+		"gcs": 1,
+		"clt": 1,
+		"cgt": 1,
+		"cbl": 1,
+		"cab": 1,
+		"ccs": 1,
+		"gtr": 0,
+		"ger": 0,
+		"gnr": 0,
+		"clr": 0,
+		"cer": 0,
+		"cnr": 0,
+		"grl": 1,
+		"gel": 1,
+		"gnl": 1,
+		"crl": 1,
+		"cel": 1,
+		"cnl": 1,
+		"sys": 1,
+		"hlt": 0,
+	}
+
+	// OpNameToRelative holds the names of instructions whose extra operand is an
+	//   offset from the address of the next instruction, instead of an address.
+	OpNameToRelative = map[string]bool{
+		"grl": true,
+		"gel": true,
+		"gnl": true,
+		"crl": true,
+		"cel": true,
+		"cnl": true,
 	}
 )
@@ -3,23 +3,61 @@ package dat
 const (
 	// GPRNum is the number of general purpose registers.
 	GPRNum = 8
+	// RegNum is the total number of registers.
+	RegNum = 14
+)
+
+// Register numbers.
+const (
+	RA uint16 = iota
+	RB
+	RC
+	RD
+	RE
+	RF
+	RH
+	RI
+	EX
+	AC
+	SP
+	PC
+	BI
+	FL
+)
+
+// Flag bits held in the fl register.
+const (
+	// FlagCarry is set if an addition carried out of, or a subtraction
+	//   borrowed into, the highest bit.
+	FlagCarry uint16 = 1 << iota
+	// FlagOverflow is set if the signed result did not fit in a word.
+	FlagOverflow
+	// FlagSign is set if the highest bit of the result is set.
+	FlagSign
+	// FlagZero is set if the result is zero.
+	FlagZero
 )
 
 var (
+	// RegNumToName maps register numbers to names.
+	// It is created from RegNamesToNum at runtime.
+	RegNumToName [RegNum]string
+
 	// RegNamesToNum maps register names to numbers.
 	RegNamesToNum = map[string]uint16{
-		"ra": 0,
-		"rb": 1,
-		"rc": 2,
-		"rd": 3,
-		"re": 4,
-		"rf": 5,
-		"rh": 6,
-		"ri": 7,
-		"ex": 8,
-		"ac": 9,
-		"sp": 10,
-		"pc": 11,
-		"bi": 12,
+		"ra": RA,
+		"rb": RB,
+		"rc": RC,
+		"rd": RD,
+		"re": RE,
+		"rf": RF,
+		"rh": RH,
+		"ri": RI,
+		"ex": EX,
+		"ac": AC,
+		"sp": SP,
+		"pc": PC,
+		"bi": BI,
+		"fl": FL,
 	}
 )
@@ -0,0 +1,184 @@
+// Package disk implements a virtual block drive and a simple filesystem.
+package disk
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/tteeoo/svc/cpu"
+	"github.com/tteeoo/svc/mem"
+	"io"
+)
+
+const (
+	// SectorSize is the number of words in a sector.
+	SectorSize = 256
+	// IRQ is the interrupt line raised when a command finishes.
+	IRQ = 2
+)
+
+// Drive registers, as offsets from where the drive is mapped.
+const (
+	// CommandRegister starts a transfer when written to.
+	CommandRegister uint16 = 0
+	// StatusRegister holds the status of the last command.
+	StatusRegister uint16 = 1
+	// SectorRegister holds the sector to transfer.
+	SectorRegister uint16 = 2
+	// BufferRegister holds the address of the memory to transfer to or from.
+	BufferRegister uint16 = 3
+	// Registers is the number of registers.
+	Registers = 4
+)
+
+// Drive commands, written to CommandRegister.
+const (
+	CommandNone  uint16 = 0
+	CommandRead  uint16 = 1
+	CommandWrite uint16 = 2
+)
+
+// Drive statuses, stored in StatusRegister.
+const (
+	StatusOK         uint16 = 0
+	StatusBadSector  uint16 = 1
+	StatusIOError    uint16 = 2
+	StatusBadCommand uint16 = 3
+)
+
+// Image is the storage backing a drive, such as an *os.File.
+type Image interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// Drive represents a block device.
+// Guest code sets the sector and the address of a SectorSize word buffer,
+//   then writes a command. The sector is transferred immediately,
+//   the status is stored, and IRQ is raised.
+type Drive struct {
+	// Image is the storage backing the drive.
+	Image Image
+	// Sectors is the number of sectors in the image.
+	Sectors uint16
+	// Bus is the memory transferred to and from, if the drive is mapped.
+	Bus *mem.Bus
+	// IRQ is the interrupt controller raised on, if the drive is mapped.
+	IRQ    *cpu.Interrupts
+	status uint16
+	sector uint16
+	buffer uint16
+}
+
+// NewDrive returns a pointer to a newly initialized Drive backed by
+//   an image of the given size in bytes.
+// Bus and IRQ must be set before it is mapped.
+func NewDrive(img Image, size int64) (*Drive, error) {
+	sectors := size / (SectorSize * 2)
+	if sectors == 0 || sectors > 0xffff {
+		return nil, fmt.Errorf("invalid image size %d (must hold 1-65535 sectors of %d bytes)", size, SectorSize*2)
+	}
+	return &Drive{
+		Image:   img,
+		Sectors: uint16(sectors),
+	}, nil
+}
+
+// ReadSector reads a sector of the image.
+func (d *Drive) ReadSector(sector uint16) ([]uint16, error) {
+	if sector >= d.Sectors {
+		return nil, fmt.Errorf("sector %x out of range", sector)
+	}
+	b := make([]byte, SectorSize*2)
+	if _, err := d.Image.ReadAt(b, int64(sector)*SectorSize*2); err != nil {
+		return nil, err
+	}
+	u := make([]uint16, SectorSize)
+	for i := range u {
+		u[i] = binary.BigEndian.Uint16(b[i*2:])
+	}
+	return u, nil
+}
+
+// WriteSector writes a sector of the image.
+func (d *Drive) WriteSector(sector uint16, u []uint16) error {
+	if sector >= d.Sectors {
+		return fmt.Errorf("sector %x out of range", sector)
+	}
+	b := make([]byte, SectorSize*2)
+	for i := 0; i < SectorSize && i < len(u); i++ {
+		binary.BigEndian.PutUint16(b[i*2:], u[i])
+	}
+	_, err := d.Image.WriteAt(b, int64(sector)*SectorSize*2)
+	return err
+}
+
+// Read implements mem.Device.
+func (d *Drive) Read(offset uint16) uint16 {
+	switch offset {
+	case StatusRegister:
+		return d.status
+	case SectorRegister:
+		return d.sector
+	case BufferRegister:
+		return d.buffer
+	}
+	return CommandNone
+}
+
+// Write implements mem.Device.
+func (d *Drive) Write(offset uint16, value uint16) {
+	switch offset {
+	case CommandRegister:
+		d.status = d.execute(value)
+		d.IRQ.Raise(IRQ)
+	case StatusRegister:
+		d.status = value
+	case SectorRegister:
+		d.sector = value
+	case BufferRegister:
+		d.buffer = value
+	}
+}
+
+// SaveState returns the registers, for snapshots.
+// The image is not included.
+func (d *Drive) SaveState() []uint16 {
+	return []uint16{d.status, d.sector, d.buffer}
+}
+
+// LoadState restores the registers from a snapshot.
+func (d *Drive) LoadState(state []uint16) error {
+	if len(state) != 3 {
+		return fmt.Errorf("invalid drive state (%d words)", len(state))
+	}
+	d.status, d.sector, d.buffer = state[0], state[1], state[2]
+	return nil
+}
+
+// execute executes a command, returning the status.
+func (d *Drive) execute(command uint16) uint16 {
+	if d.sector >= d.Sectors {
+		return StatusBadSector
+	}
+	switch command {
+	case CommandRead:
+		u, err := d.ReadSector(d.sector)
+		if err != nil {
+			return StatusIOError
+		}
+		for i, w := range u {
+			d.Bus.Set(d.buffer+uint16(i), w)
+		}
+	case CommandWrite:
+		u := make([]uint16, SectorSize)
+		for i := range u {
+			u[i] = d.Bus.Get(d.buffer + uint16(i))
+		}
+		if err := d.WriteSector(d.sector, u); err != nil {
+			return StatusIOError
+		}
+	default:
+		return StatusBadCommand
+	}
+	return StatusOK
+}
@@ -0,0 +1,405 @@
+package disk
+
+import (
+	"fmt"
+)
+
+// Filesystem layout.
+//
+// Sector 0 holds the superblock: the magic number, version, number of sectors,
+//   and the start and size (in sectors) of the FAT and directory table,
+//   followed by the first data sector.
+// The FAT (file allocation table) holds one word per sector of the drive:
+//   FATFree, FATEnd for the last sector of a file, FATReserved for sectors
+//   used by the filesystem itself, or else the next sector of the file.
+// The directory table is a list of EntrySize word entries: NameSize words
+//   holding the null-padded name (one character per word), then the first
+//   sector of the file and its size in words. Entries starting with a null
+//   word are unused.
+const (
+	// Magic identifies a formatted drive ("SF").
+	Magic uint16 = 0x5346
+	// Version is the filesystem version.
+	Version uint16 = 1
+	// NameSize is the maximum length of a file name.
+	NameSize = 12
+	// EntrySize is the number of words in a directory entry.
+	EntrySize = 16
+	// DirSectors is the number of directory table sectors created by Format.
+	DirSectors = 4
+)
+
+// FAT values.
+const (
+	FATFree     uint16 = 0x0000
+	FATEnd      uint16 = 0xffff
+	FATReserved uint16 = 0xfffe
+)
+
+// Superblock describes the layout of a filesystem.
+type Superblock struct {
+	Sectors    uint16
+	FATStart   uint16
+	FATSectors uint16
+	DirStart   uint16
+	DirSectors uint16
+	DataStart  uint16
+}
+
+// Entry represents a file in the directory table.
+type Entry struct {
+	Name  string
+	Start uint16
+	Size  uint16
+}
+
+// FS represents a filesystem on a drive.
+type FS struct {
+	Drive *Drive
+	Super Superblock
+}
+
+// Format creates an empty filesystem on a drive.
+func Format(d *Drive) (*FS, error) {
+
+	// Calculate layout
+	fatSectors := (int(d.Sectors) + SectorSize - 1) / SectorSize
+	dataStart := 1 + fatSectors + DirSectors
+	if dataStart >= int(d.Sectors) {
+		return nil, fmt.Errorf("drive is too small (%d sectors) to format", d.Sectors)
+	}
+	fs := &FS{
+		Drive: d,
+		Super: Superblock{
+			Sectors:    d.Sectors,
+			FATStart:   1,
+			FATSectors: uint16(fatSectors),
+			DirStart:   uint16(1 + fatSectors),
+			DirSectors: DirSectors,
+			DataStart:  uint16(dataStart),
+		},
+	}
+
+	// Write superblock
+	sb := []uint16{
+		Magic,
+		Version,
+		fs.Super.Sectors,
+		fs.Super.FATStart,
+		fs.Super.FATSectors,
+		fs.Super.DirStart,
+		fs.Super.DirSectors,
+		fs.Super.DataStart,
+	}
+	if err := d.WriteSector(0, sb); err != nil {
+		return nil, err
+	}
+
+	// Write FAT, reserving the filesystem's own sectors
+	fat := make([]uint16, int(fs.Super.FATSectors)*SectorSize)
+	for i := 0; i < dataStart; i++ {
+		fat[i] = FATReserved
+	}
+	if err := fs.writeFAT(fat); err != nil {
+		return nil, err
+	}
+
+	// Clear directory table
+	return fs, fs.writeDir(make([]uint16, int(fs.Super.DirSectors)*SectorSize))
+}
+
+// Open reads the filesystem on a drive.
+// It returns an error if the superblock describes a layout that does not
+//   fit the drive, so a corrupt image cannot make later operations fail
+//   out of bounds.
+func Open(d *Drive) (*FS, error) {
+	sb, err := d.ReadSector(0)
+	if err != nil {
+		return nil, err
+	}
+	if sb[0] != Magic {
+		return nil, fmt.Errorf("drive is not formatted")
+	}
+	if sb[1] != Version {
+		return nil, fmt.Errorf("unsupported filesystem version %d", sb[1])
+	}
+	fs := &FS{
+		Drive: d,
+		Super: Superblock{
+			Sectors:    sb[2],
+			FATStart:   sb[3],
+			FATSectors: sb[4],
+			DirStart:   sb[5],
+			DirSectors: sb[6],
+			DataStart:  sb[7],
+		},
+	}
+	if err := fs.Super.validate(d.Sectors); err != nil {
+		return nil, fmt.Errorf("corrupt superblock: %w", err)
+	}
+	return fs, nil
+}
+
+// validate checks that the layout fits a drive with the given number of
+//   sectors: the FAT covers every sector, and the FAT, directory table,
+//   and data sectors follow the superblock in order without overlapping.
+func (sb Superblock) validate(sectors uint16) error {
+	switch {
+	case sb.Sectors == 0 || sb.Sectors > sectors:
+		return fmt.Errorf("%d sectors on a drive of %d", sb.Sectors, sectors)
+	case int(sb.Sectors) > int(sb.FATSectors)*SectorSize:
+		return fmt.Errorf("%d FAT sectors cannot hold %d sectors", sb.FATSectors, sb.Sectors)
+	case sb.FATStart == 0 || sb.DirSectors == 0,
+		int(sb.FATStart)+int(sb.FATSectors) > int(sb.DirStart),
+		int(sb.DirStart)+int(sb.DirSectors) > int(sb.DataStart),
+		sb.DataStart >= sb.Sectors:
+		return fmt.Errorf("FAT, directory table, and data sectors are out of order or out of range")
+	}
+	return nil
+}
+
+// List returns the files in the directory table.
+func (fs *FS) List() ([]Entry, error) {
+	dir, err := fs.readDir()
+	if err != nil {
+		return nil, err
+	}
+	entries := []Entry{}
+	for i := 0; i < len(dir); i += EntrySize {
+		if dir[i] != 0 {
+			entries = append(entries, decodeEntry(dir[i:i+EntrySize]))
+		}
+	}
+	return entries, nil
+}
+
+// ReadFile returns the contents of a file.
+func (fs *FS) ReadFile(name string) ([]uint16, error) {
+	dir, err := fs.readDir()
+	if err != nil {
+		return nil, err
+	}
+	i := findEntry(dir, name)
+	if i < 0 {
+		return nil, fmt.Errorf("file \"%s\" does not exist", name)
+	}
+	e := decodeEntry(dir[i : i+EntrySize])
+	fat, err := fs.readFAT()
+	if err != nil {
+		return nil, err
+	}
+
+	// Follow the chain of sectors
+	data := make([]uint16, 0, e.Size)
+	for s := e.Start; len(data) < int(e.Size); s = fat[s] {
+		if s < fs.Super.DataStart || s >= fs.Super.Sectors {
+			return nil, fmt.Errorf("file \"%s\" has a broken sector chain", name)
+		}
+		u, err := fs.Drive.ReadSector(s)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, u...)
+	}
+	return data[:e.Size], nil
+}
+
+// WriteFile creates or replaces a file.
+// A file being replaced keeps its contents until the new ones are written,
+//   so it is left as it was if there is not enough free space.
+func (fs *FS) WriteFile(name string, data []uint16) error {
+	if len(name) == 0 || len(name) > NameSize {
+		return fmt.Errorf("file name \"%s\" must be 1-%d characters", name, NameSize)
+	}
+	if len(data) > 0xffff {
+		return fmt.Errorf("file \"%s\" is too large (%d words)", name, len(data))
+	}
+	dir, err := fs.readDir()
+	if err != nil {
+		return err
+	}
+	fat, err := fs.readFAT()
+	if err != nil {
+		return err
+	}
+
+	// Find the file's entry, or a free one
+	i := findEntry(dir, name)
+	replacing := i >= 0
+	if !replacing {
+		i = findEntry(dir, "")
+		if i < 0 {
+			return fmt.Errorf("directory table is full")
+		}
+	}
+	old := decodeEntry(dir[i : i+EntrySize])
+
+	// Allocate sectors
+	needed := (len(data) + SectorSize - 1) / SectorSize
+	sectors := []uint16{}
+	for s := int(fs.Super.DataStart); s < int(fs.Super.Sectors) && len(sectors) < needed; s++ {
+		if fat[s] == FATFree {
+			sectors = append(sectors, uint16(s))
+		}
+	}
+	if len(sectors) < needed {
+		return fmt.Errorf("not enough free space for \"%s\"", name)
+	}
+
+	// Write data and link sectors
+	for j, s := range sectors {
+		end := (j + 1) * SectorSize
+		if end > len(data) {
+			end = len(data)
+		}
+		if err := fs.Drive.WriteSector(s, data[j*SectorSize:end]); err != nil {
+			return err
+		}
+		fat[s] = FATEnd
+		if j > 0 {
+			fat[sectors[j-1]] = s
+		}
+	}
+	if err := fs.writeFAT(fat); err != nil {
+		return err
+	}
+
+	// Write entry
+	e := Entry{Name: name, Size: uint16(len(data))}
+	if len(sectors) > 0 {
+		e.Start = sectors[0]
+	}
+	copy(dir[i:i+EntrySize], encodeEntry(e))
+	if err := fs.writeDir(dir); err != nil {
+		return err
+	}
+
+	// Free the old contents
+	if !replacing || old.Size == 0 {
+		return nil
+	}
+	fs.freeChain(fat, old.Start)
+	return fs.writeFAT(fat)
+}
+
+// Remove deletes a file.
+func (fs *FS) Remove(name string) error {
+	dir, err := fs.readDir()
+	if err != nil {
+		return err
+	}
+	i := findEntry(dir, name)
+	if i < 0 {
+		return notExistError(name)
+	}
+	e := decodeEntry(dir[i : i+EntrySize])
+	fat, err := fs.readFAT()
+	if err != nil {
+		return err
+	}
+	if e.Size > 0 {
+		fs.freeChain(fat, e.Start)
+		if err := fs.writeFAT(fat); err != nil {
+			return err
+		}
+	}
+
+	copy(dir[i:i+EntrySize], make([]uint16, EntrySize))
+	return fs.writeDir(dir)
+}
+
+// freeChain marks the chain of sectors starting at start as free in the FAT.
+func (fs *FS) freeChain(fat []uint16, start uint16) {
+	for s := start; s >= fs.Super.DataStart && s < fs.Super.Sectors && fat[s] != FATFree; {
+		next := fat[s]
+		fat[s] = FATFree
+		s = next
+	}
+}
+
+// notExistError is returned when a file does not exist.
+type notExistError string
+
+// Error implements the error interface.
+func (e notExistError) Error() string {
+	return fmt.Sprintf("file \"%s\" does not exist", string(e))
+}
+
+// findEntry returns the index in the directory table of a file, or -1.
+func findEntry(dir []uint16, name string) int {
+	for i := 0; i+EntrySize <= len(dir); i += EntrySize {
+		if name == "" && dir[i] == 0 {
+			return i
+		}
+		if name != "" && dir[i] != 0 && decodeEntry(dir[i:i+EntrySize]).Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// decodeEntry converts words in the directory table to an Entry.
+func decodeEntry(u []uint16) Entry {
+	name := ""
+	for _, c := range u[:NameSize] {
+		if c == 0 {
+			break
+		}
+		name += string(rune(c))
+	}
+	return Entry{
+		Name:  name,
+		Start: u[NameSize],
+		Size:  u[NameSize+1],
+	}
+}
+
+// encodeEntry converts an Entry to words in the directory table.
+func encodeEntry(e Entry) []uint16 {
+	u := make([]uint16, EntrySize)
+	for i, c := range e.Name {
+		u[i] = uint16(c)
+	}
+	u[NameSize] = e.Start
+	u[NameSize+1] = e.Size
+	return u
+}
+
+// readSectors reads consecutive sectors.
+func (fs *FS) readSectors(start, count uint16) ([]uint16, error) {
+	u := []uint16{}
+	for i := uint16(0); i < count; i++ {
+		s, err := fs.Drive.ReadSector(start + i)
+		if err != nil {
+			return nil, err
+		}
+		u = append(u, s...)
+	}
+	return u, nil
+}
+
+// writeSectors writes consecutive sectors.
+func (fs *FS) writeSectors(start uint16, u []uint16) error {
+	for i := 0; i < len(u); i += SectorSize {
+		if err := fs.Drive.WriteSector(start+uint16(i/SectorSize), u[i:i+SectorSize]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *FS) readFAT() ([]uint16, error) {
+	return fs.readSectors(fs.Super.FATStart, fs.Super.FATSectors)
+}
+
+func (fs *FS) writeFAT(fat []uint16) error {
+	return fs.writeSectors(fs.Super.FATStart, fat)
+}
+
+func (fs *FS) readDir() ([]uint16, error) {
+	return fs.readSectors(fs.Super.DirStart, fs.Super.DirSectors)
+}
+
+func (fs *FS) writeDir(dir []uint16) error {
+	return fs.writeSectors(fs.Super.DirStart, dir)
+}
@@ -0,0 +1,166 @@
+package disk_test
+
+import (
+	"github.com/tteeoo/svc/disk"
+	"reflect"
+	"testing"
+)
+
+// image is an Image held in memory.
+type image []byte
+
+func (img image) ReadAt(b []byte, off int64) (int, error) {
+	return copy(b, img[off:]), nil
+}
+
+func (img image) WriteAt(b []byte, off int64) (int, error) {
+	return copy(img[off:], b), nil
+}
+
+// newDrive returns a drive with the given number of sectors.
+func newDrive(t *testing.T, sectors int) *disk.Drive {
+	size := int64(sectors) * disk.SectorSize * 2
+	d, err := disk.NewDrive(make(image, size), size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// words returns n words counting up from start.
+func words(start, n int) []uint16 {
+	u := make([]uint16, n)
+	for i := range u {
+		u[i] = uint16(start + i)
+	}
+	return u
+}
+
+func TestRoundTrip(t *testing.T) {
+	d := newDrive(t, 32)
+	if _, err := disk.Format(d); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := disk.Open(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]uint16{
+		"empty":  {},
+		"small":  words(1, 10),
+		"sector": words(2, disk.SectorSize),
+		"large":  words(3, disk.SectorSize*3+1),
+	}
+	for name, data := range files {
+		if err := fs.WriteFile(name, data); err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+	}
+	for name, data := range files {
+		got, err := fs.ReadFile(name)
+		if err != nil {
+			t.Fatalf("reading %s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, data) {
+			t.Errorf("%s: read %d words, want %d", name, len(got), len(data))
+		}
+	}
+
+	// Replace a file with a larger one
+	files["small"] = words(4, disk.SectorSize*2)
+	if err := fs.WriteFile("small", files["small"]); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := fs.ReadFile("small"); err != nil || !reflect.DeepEqual(got, files["small"]) {
+		t.Errorf("small: read %d words (%v) after replacing it", len(got), err)
+	}
+
+	// Remove everything, which frees all of the sectors
+	for name := range files {
+		if err := fs.Remove(name); err != nil {
+			t.Fatalf("removing %s: %v", name, err)
+		}
+	}
+	if entries, err := fs.List(); err != nil || len(entries) != 0 {
+		t.Errorf("List returned %v (%v) after removing every file", entries, err)
+	}
+	if _, err := fs.ReadFile("small"); err == nil {
+		t.Errorf("ReadFile returned no error for a removed file")
+	}
+	free := int(fs.Super.Sectors-fs.Super.DataStart) * disk.SectorSize
+	if err := fs.WriteFile("full", words(5, free)); err != nil {
+		t.Errorf("writing a file filling the drive: %v", err)
+	}
+}
+
+func TestOutOfSpace(t *testing.T) {
+	fs, err := disk.Format(newDrive(t, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	free := int(fs.Super.Sectors-fs.Super.DataStart) * disk.SectorSize
+
+	if err := fs.WriteFile("big", words(1, free+1)); err == nil {
+		t.Errorf("WriteFile returned no error for a file larger than the drive")
+	}
+	if _, err := fs.ReadFile("big"); err == nil {
+		t.Errorf("a file that did not fit was created")
+	}
+
+	// A file that cannot be replaced keeps its contents
+	old := words(2, free-disk.SectorSize)
+	if err := fs.WriteFile("file", old); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile("file", words(3, disk.SectorSize*2)); err == nil {
+		t.Errorf("WriteFile returned no error replacing a file on a full drive")
+	}
+	if got, err := fs.ReadFile("file"); err != nil || !reflect.DeepEqual(got, old) {
+		t.Errorf("file: read %d words (%v) after failing to replace it", len(got), err)
+	}
+
+	// Replacing it with a file that fits alongside it works
+	data := words(4, disk.SectorSize)
+	if err := fs.WriteFile("file", data); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := fs.ReadFile("file"); err != nil || !reflect.DeepEqual(got, data) {
+		t.Errorf("file: read %d words (%v) after replacing it", len(got), err)
+	}
+}
+
+func TestCorruptSuperblock(t *testing.T) {
+	tests := []struct {
+		name  string
+		word  int
+		value uint16
+	}{
+		{"magic", 0, 0},
+		{"version", 1, 2},
+		{"sectors past the drive", 2, 17},
+		{"sectors past the FAT", 4, 0},
+		{"FAT at the superblock", 3, 0},
+		{"FAT overlapping directory", 5, 1},
+		{"directory overlapping data", 7, 2},
+		{"data past the end", 7, 16},
+		{"no directory", 6, 0},
+	}
+	for _, test := range tests {
+		d := newDrive(t, 16)
+		if _, err := disk.Format(d); err != nil {
+			t.Fatal(err)
+		}
+		sb, err := d.ReadSector(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sb[test.word] = test.value
+		if err := d.WriteSector(0, sb); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := disk.Open(d); err == nil {
+			t.Errorf("%s: Open returned no error", test.name)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Package kbd implements keyboard input devices.
+package kbd
+
+import (
+	"bufio"
+	"github.com/chzyer/readline"
+	"github.com/tteeoo/svc/cpu"
+	"io"
+	"sync"
+)
+
+// IRQ is the interrupt line raised when a key is made available.
+const IRQ = 1
+
+// Keyboard registers, as offsets from where the keyboard is mapped.
+const (
+	// StatusRegister is 1 when a key is available.
+	// Writing to it acknowledges the key, making the next one available.
+	StatusRegister uint16 = 0
+	// DataRegister holds the available key.
+	DataRegister uint16 = 1
+	// Registers is the number of registers.
+	Registers = 2
+)
+
+// Keyboard represents a keyboard device.
+// Keys are made available to guest code one at a time.
+// IRQ is raised whenever a key becomes available.
+type Keyboard struct {
+	// OnInterrupt, if set, is called instead of queueing Ctrl-C.
+	OnInterrupt func()
+	// OnKey, if set, is called with the keys decoded by Listen instead of
+	//   queueing them, so they can be recorded and queued later with Push.
+	OnKey func(key uint16)
+	irq   *cpu.Interrupts
+	mu    sync.Mutex
+	queue []uint16
+}
+
+// NewKeyboard returns a pointer to a newly initialized Keyboard
+//   raising interrupts on irq.
+func NewKeyboard(irq *cpu.Interrupts) *Keyboard {
+	return &Keyboard{
+		irq: irq,
+	}
+}
+
+// Push queues a key.
+func (k *Keyboard) Push(key uint16) {
+	if key == Ctrl|'c' && k.OnInterrupt != nil {
+		k.OnInterrupt()
+		return
+	}
+	k.mu.Lock()
+	k.queue = append(k.queue, key)
+	available := len(k.queue) == 1
+	k.mu.Unlock()
+	if available {
+		k.irq.Raise(IRQ)
+	}
+}
+
+// Read implements mem.Device.
+func (k *Keyboard) Read(offset uint16) uint16 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if len(k.queue) == 0 {
+		return 0
+	}
+	switch offset {
+	case StatusRegister:
+		return 1
+	case DataRegister:
+		return k.queue[0]
+	}
+	return 0
+}
+
+// Write implements mem.Device.
+func (k *Keyboard) Write(offset uint16, value uint16) {
+	if offset != StatusRegister {
+		return
+	}
+	k.mu.Lock()
+	if len(k.queue) == 0 {
+		k.mu.Unlock()
+		return
+	}
+	k.queue = k.queue[1:]
+	available := len(k.queue) > 0
+	k.mu.Unlock()
+	if available {
+		k.irq.Raise(IRQ)
+	}
+}
+
+// SaveState returns the queued keys, for snapshots.
+func (k *Keyboard) SaveState() []uint16 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return append([]uint16{}, k.queue...)
+}
+
+// LoadState restores the queued keys from a snapshot.
+func (k *Keyboard) LoadState(state []uint16) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.queue = append([]uint16{}, state...)
+	return nil
+}
+
+// Listen decodes keys from r and queues them in the background
+//   until r returns an error.
+func (k *Keyboard) Listen(r io.Reader) {
+	go func() {
+		br := bufio.NewReader(r)
+		for {
+			key, err := decode(br)
+			if err != nil {
+				return
+			}
+			if key == 0 {
+				continue
+			}
+			if key == Ctrl|'c' && k.OnInterrupt != nil {
+				k.OnInterrupt()
+			} else if k.OnKey != nil {
+				k.OnKey(key)
+			} else {
+				k.Push(key)
+			}
+		}
+	}()
+}
+
+// Raw puts the terminal connected to fd into raw mode,
+//   returning a function that restores its previous state.
+func Raw(fd int) (func(), error) {
+	state, err := readline.MakeRaw(fd)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		readline.Restore(fd, state)
+	}, nil
+}
+
+// IsTerminal returns true if fd is connected to a terminal.
+func IsTerminal(fd int) bool {
+	return readline.IsTerminal(fd)
+}
@@ -0,0 +1,136 @@
+package kbd
+
+import (
+	"bufio"
+)
+
+// Modifier bits, stored in the high byte of a key.
+const (
+	Shift uint16 = 0x100
+	Alt   uint16 = 0x200
+	Ctrl  uint16 = 0x400
+)
+
+// Key codes stored in the low byte of a key.
+// Printable characters use their ASCII codes.
+const (
+	KeyBackspace uint16 = 0x08
+	KeyTab       uint16 = 0x09
+	KeyEnter     uint16 = 0x0a
+	KeyEscape    uint16 = 0x1b
+	KeyUp        uint16 = 0x80
+	KeyDown      uint16 = 0x81
+	KeyRight     uint16 = 0x82
+	KeyLeft      uint16 = 0x83
+	KeyHome      uint16 = 0x84
+	KeyEnd       uint16 = 0x85
+	KeyInsert    uint16 = 0x86
+	KeyDelete    uint16 = 0x87
+	KeyPageUp    uint16 = 0x88
+	KeyPageDown  uint16 = 0x89
+)
+
+// decode reads a single key from terminal input.
+// It returns 0 for input that does not map to a key.
+func decode(r *bufio.Reader) (uint16, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case b == 0x1b:
+		// A lone escape cannot be told apart from the start of a sequence
+		//   unless the rest of the sequence has already arrived
+		if r.Buffered() == 0 {
+			return KeyEscape, nil
+		}
+		return decodeEscape(r)
+	case b == '\r' || b == '\n':
+		return KeyEnter, nil
+	case b == 0x7f || b == 0x08:
+		return KeyBackspace, nil
+	case b == '\t':
+		return KeyTab, nil
+	case b == 0:
+		return Ctrl | ' ', nil
+	case b < 0x1b:
+		return Ctrl | uint16('a'+b-1), nil
+	case b < 0x80:
+		return uint16(b), nil
+	}
+	return 0, nil
+}
+
+// decodeEscape reads the rest of an escape sequence.
+func decodeEscape(r *bufio.Reader) (uint16, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	// Alt + key
+	if b != '[' && b != 'O' {
+		r.UnreadByte()
+		key, err := decode(r)
+		if key == 0 {
+			return 0, err
+		}
+		return Alt | key, err
+	}
+
+	// Read parameters up to the final byte, e.g. "1;5A"
+	params := []int{0}
+	for {
+		b, err = r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b >= '0' && b <= '9' {
+			params[len(params)-1] = params[len(params)-1]*10 + int(b-'0')
+		} else if b == ';' {
+			params = append(params, 0)
+		} else {
+			break
+		}
+	}
+
+	// Modifier parameters are 1 + (shift | alt << 1 | ctrl << 2)
+	var mods uint16
+	if len(params) > 1 && params[1] > 1 {
+		mods = uint16(params[1]-1) << 8 & (Shift | Alt | Ctrl)
+	}
+
+	switch b {
+	case 'A':
+		return mods | KeyUp, nil
+	case 'B':
+		return mods | KeyDown, nil
+	case 'C':
+		return mods | KeyRight, nil
+	case 'D':
+		return mods | KeyLeft, nil
+	case 'H':
+		return mods | KeyHome, nil
+	case 'F':
+		return mods | KeyEnd, nil
+	case 'Z':
+		return Shift | KeyTab, nil
+	case '~':
+		switch params[0] {
+		case 1, 7:
+			return mods | KeyHome, nil
+		case 2:
+			return mods | KeyInsert, nil
+		case 3:
+			return mods | KeyDelete, nil
+		case 4, 8:
+			return mods | KeyEnd, nil
+		case 5:
+			return mods | KeyPageUp, nil
+		case 6:
+			return mods | KeyPageDown, nil
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,109 @@
+package machine
+
+import (
+	"github.com/tteeoo/svc/cpu"
+	"github.com/tteeoo/svc/disk"
+	"github.com/tteeoo/svc/kbd"
+	"github.com/tteeoo/svc/mem"
+	"github.com/tteeoo/svc/replay"
+	"github.com/tteeoo/svc/snapshot"
+	"github.com/tteeoo/svc/timer"
+	"os"
+)
+
+// AttachDrive maps a drive backed by an image file onto the CPU's bus.
+// The returned file should be closed when the drive is no longer used.
+func AttachDrive(c *cpu.CPU, image string) (*disk.Drive, *os.File, error) {
+	f, err := os.OpenFile(image, os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	d, err := disk.NewDrive(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if err := MapDrive(c, d); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return d, f, nil
+}
+
+// MapDrive maps a drive onto the CPU's bus.
+func MapDrive(c *cpu.CPU, d *disk.Drive) error {
+	d.Bus = c.Mem
+	d.IRQ = c.IRQ
+	start := c.Mem.System(mem.DiskWord)
+	return c.Mem.Map(start, start+disk.Registers-1, d)
+}
+
+// AttachKeyboard maps a keyboard onto the CPU's bus.
+// If onInterrupt is not nil, it is called when Ctrl-C is pressed.
+// Keys are not read until Listen is called.
+func AttachKeyboard(c *cpu.CPU, onInterrupt func()) (*kbd.Keyboard, error) {
+	k := kbd.NewKeyboard(c.IRQ)
+	k.OnInterrupt = onInterrupt
+	start := c.Mem.System(mem.KeyboardWord)
+	if err := c.Mem.Map(start, start+kbd.Registers-1, k); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// AttachTimer maps a timer onto the CPU's bus.
+func AttachTimer(c *cpu.CPU) (*timer.Timer, error) {
+	t := timer.NewTimer(c.IRQ)
+	start := c.Mem.System(mem.TimerWord)
+	if err := c.Mem.Map(start, start+timer.Registers-1, t); err != nil {
+		return nil, err
+	}
+	c.Observe(t)
+	return t, nil
+}
+
+// Replay maps the devices that were attached when a tape was recorded
+//   onto the CPU's bus, and replays the tape.
+// It returns the player, and the devices by the names snapshots hold them under.
+func Replay(c *cpu.CPU, tape *replay.Tape) (*replay.Player, map[string]snapshot.Device, error) {
+	p := replay.NewPlayer(c, tape)
+	devices := make(map[string]snapshot.Device)
+
+	// Timer
+	t, err := AttachTimer(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	p.Timer(t)
+	devices["timer"] = t
+
+	// Keyboard
+	if tape.Keyboard {
+		k, err := AttachKeyboard(c, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		p.Keyboard(k)
+		devices["keyboard"] = k
+	}
+
+	// Drive, whose contents are played from the tape
+	if tape.Sectors != 0 {
+		d, err := disk.NewDrive(nil, int64(tape.Sectors)*disk.SectorSize*2)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := MapDrive(c, d); err != nil {
+			return nil, nil, err
+		}
+		p.Drive(d)
+		devices["drive"] = d
+	}
+
+	return p, devices, nil
+}
@@ -0,0 +1,119 @@
+// Package machine sets up virtual machines from programs, snapshots,
+//   and recordings, for svc and svd.
+package machine
+
+import (
+	"fmt"
+	"github.com/tteeoo/svc/cpu"
+	"github.com/tteeoo/svc/mem"
+	"github.com/tteeoo/svc/replay"
+	"github.com/tteeoo/svc/snapshot"
+	"github.com/tteeoo/svc/svb"
+	"io/ioutil"
+)
+
+// Source is what a virtual machine is started from: a program,
+//   a snapshot, or a recording.
+type Source struct {
+	// Bytes holds the program, unless starting from a snapshot.
+	Bytes   []byte
+	Program *svb.File
+	// Snapshot is set when starting from a snapshot.
+	Snapshot *snapshot.Snapshot
+	// Tape is set when starting from a recording.
+	Tape *replay.Tape
+	// Layout is the memory layout to create the machine with.
+	Layout mem.Layout
+}
+
+// Open reads a program, snapshot, or tape file, whichever name is not empty.
+// The memory layout is the one the program was assembled for, the snapshot
+//   was taken with, or the recording was made with, falling back to layout.
+//   If layoutSet is true, layout was chosen explicitly, so it must match.
+func Open(programFile, snapshotFile, tapeFile string, layout mem.Layout, layoutSet bool) (*Source, error) {
+	s := &Source{}
+	var err error
+	switch {
+	case snapshotFile != "":
+		s.Snapshot, err = snapshot.ReadFile(snapshotFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading snapshot file: %w", err)
+		}
+		if layoutSet && s.Snapshot.Layout() != layout.Resolved() {
+			return nil, fmt.Errorf("the layout options do not match the layout of the snapshot")
+		}
+		layout = s.Snapshot.Layout()
+	case tapeFile != "":
+		s.Tape, err = replay.ReadFile(tapeFile)
+		if err == nil {
+			s.Bytes = s.Tape.Program
+			s.Program, err = svb.Parse(s.Bytes)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading tape file: %w", err)
+		}
+		if layoutSet && s.Tape.Layout != layout.Resolved() {
+			return nil, fmt.Errorf("the layout options do not match the layout of the recording")
+		}
+		layout = s.Tape.Layout
+	default:
+		s.Bytes, err = ioutil.ReadFile(programFile)
+		if err == nil {
+			s.Program, err = svb.Parse(s.Bytes)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading program file: %w", err)
+		}
+		if l, ok := s.Program.Layout(); ok {
+			if layoutSet && l.Resolved() != layout.Resolved() {
+				return nil, fmt.Errorf("the layout options do not match the layout the program was assembled for")
+			}
+			layout = l
+		}
+	}
+	if err := layout.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid memory layout: %w", err)
+	}
+	s.Layout = layout
+	return s, nil
+}
+
+// Load loads the program into the CPU's memory, moves the heap offset
+//   past it, and returns the address of main.
+// It does nothing when starting from a snapshot, which is restored instead.
+func (s *Source) Load(c *cpu.CPU) (uint16, error) {
+	if s.Program == nil {
+		return 0, nil
+	}
+	a, mainAddress, programSize, err := s.Program.Load(c)
+	if err != nil {
+		return 0, fmt.Errorf("loading program: %w", err)
+	}
+	c.Mem.Mem = a
+	c.Mem.HeapOffset += programSize
+	return mainAddress, nil
+}
+
+// Symbols returns the symbol table read from a symbol file written by
+//   sva -sym, if its name is not empty, or else the program's.
+func (s *Source) Symbols(symbolsFile string) (svb.Symbols, error) {
+	if symbolsFile != "" {
+		b, err := ioutil.ReadFile(symbolsFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading symbol file: %w", err)
+		}
+		symbols, err := svb.ParseSymbols(b)
+		if err != nil {
+			return nil, fmt.Errorf("reading symbol file: %w", err)
+		}
+		return symbols, nil
+	}
+	if s.Program == nil {
+		return nil, nil
+	}
+	symbols, err := s.Program.Symbols()
+	if err != nil {
+		return nil, fmt.Errorf("reading program file: %w", err)
+	}
+	return symbols, nil
+}
@@ -1,44 +1,402 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"github.com/tteeoo/svc/cpu"
+	"github.com/tteeoo/svc/kbd"
+	"github.com/tteeoo/svc/machine"
 	"github.com/tteeoo/svc/mem"
+	"github.com/tteeoo/svc/profile"
+	"github.com/tteeoo/svc/replay"
+	"github.com/tteeoo/svc/snapshot"
 	"github.com/tteeoo/svc/svb"
+	"github.com/tteeoo/svc/trace"
+	"github.com/tteeoo/svc/util"
 	"github.com/tteeoo/svc/vga"
-	"io/ioutil"
+	"io"
 	"os"
+	"os/signal"
+	"strings"
 )
 
+// Command-line options.
+var (
+	env           util.StringList
+	layoutFlags   = util.LayoutFlags()
+	useKeyboard   = flag.Bool("kbd", false, "attach the terminal as a keyboard (puts it in raw mode)")
+	keysFile      = flag.String("keys", "", "attach a keyboard reading scripted input from a file")
+	diskFile      = flag.String("disk", "", "attach a drive backed by an image file")
+	clockRate     = flag.Uint64("clock", 0, "limit execution to a number of instructions per second (0 for no limit)")
+	saveFile      = flag.String("save", "", "save a snapshot of the machine to a file when execution stops")
+	restoreFile   = flag.String("restore", "", "resume execution from a snapshot file instead of running a program")
+	recordFile    = flag.String("record", "", "record the inputs of the program to a file, for replaying with -replay")
+	replayFile    = flag.String("replay", "", "replay a recording made with -record instead of running a program")
+	symbolsFile   = flag.String("symbols", "", "read subroutine names from a symbol file written by sva -sym, instead of the program")
+	useTrace      = flag.Bool("trace", false, "write a trace of the executed instructions to stderr")
+	traceFile     = flag.String("trace-file", "", "write the trace to a file instead of stderr")
+	traceJSON     = flag.Bool("trace-json", false, "write the trace as lines of JSON")
+	traceRanges   util.StringList
+	traceSubs     util.StringList
+	profileFile   = flag.String("profile", "", "write a profile of the executed instructions to a file, for go tool pprof")
+	profileReport = flag.Bool("profile-report", false, "write a report of the executed instructions to stderr")
+)
+
+func init() {
+	flag.Var(&env, "e", "set an environment entry (key=value), can be repeated")
+	flag.Var(&traceRanges, "trace-range", "only trace instructions in a range of addresses (hex start-end), can be repeated")
+	flag.Var(&traceSubs, "trace-sub", "only trace instructions in a subroutine, can be repeated")
+	flag.Usage = func() {
+		fmt.Printf("run like this: %s [options] <svb file> [args]...\n", os.Args[0])
+		fmt.Printf("           or: %s [options] -restore <snapshot file>\n", os.Args[0])
+		fmt.Printf("           or: %s [options] -replay <tape file>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+}
+
+// session holds the devices attached to the machine, and what records
+//   or replays their inputs.
+type session struct {
+	// devices are the devices saved in snapshots, by name.
+	devices  map[string]snapshot.Device
+	tape     *replay.Tape
+	recorder *replay.Recorder
+	player   *replay.Player
+	// restore puts the terminal back in the mode it was in before
+	//   the keyboard was attached.
+	restore func()
+	// files are closed when the machine stops.
+	files []*os.File
+}
+
 func main() {
+	flag.Parse()
+	checkArgs()
 
-	// Get program file
-	if len(os.Args) < 2 {
-		fmt.Printf("run like this: %s <svb file>\n", os.Args[0])
+	// Read the program, snapshot, or recording
+	layout, layoutSet, err := layoutFlags()
+	if err != nil {
+		fmt.Println("error in memory layout:", err)
 		os.Exit(1)
 	}
-	programFile := os.Args[1]
-
-	// Open program
-	b, err := ioutil.ReadFile(programFile)
+	src, err := machine.Open(flag.Arg(0), *restoreFile, *replayFile, layout, layoutSet)
 	if err != nil {
-		fmt.Println("error reading program file:", err)
+		fmt.Println("error:", err)
 		os.Exit(1)
 	}
 
-	// Initialize devices
-	m := mem.NewRAM(mem.AddressSpace{}, 80, 25)
+	// Initialize the machine and load the program
+	m := mem.NewRAMLayout(mem.AddressSpace{}, src.Layout)
 	v := vga.NewVGA(m)
-	c := cpu.NewCPU(m, v)
+	c := cpu.NewCPU(mem.NewBus(m), v)
+	mainAddress, err := src.Load(c)
+	if err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+	c.ClockRate = *clockRate
+	symbols, err := src.Symbols(*symbolsFile)
+	if err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+
+	// Observe instructions
+	tracer, traceOut := startTrace(c, symbols)
+	var profiler *profile.Profiler
+	if *profileFile != "" || *profileReport {
+		profiler = profile.NewProfiler()
+		c.Observe(profiler)
+	}
 
-	// Load program into memory
-	mainAddress := uint16(0)
-	programSize := uint16(0)
-	m.Mem, mainAddress, programSize = svb.LoadProgram(c, b)
+	// Attach devices
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := cpu.Startup{Env: env}
+	if flag.NArg() > 0 {
+		s.Args = flag.Args()[1:]
+	}
+	sess := attachDevices(c, src, &s, cancel)
 
-	// Calculate heap offset
-	m.HeapOffset += programSize
+	// Stop on Ctrl-C instead of exiting, so a snapshot or recording can be saved
+	if *saveFile != "" || *recordFile != "" {
+		interrupts := make(chan os.Signal, 1)
+		signal.Notify(interrupts, os.Interrupt)
+		go func() {
+			<-interrupts
+			cancel()
+		}()
+	}
 
 	// Run!
-	c.Run(mainAddress)
+	var result cpu.Result
+	if src.Snapshot != nil {
+		if err := src.Snapshot.Restore(c, sess.devices); err != nil {
+			sess.restore()
+			fmt.Println("error restoring snapshot:", err)
+			os.Exit(1)
+		}
+		v.Redraw()
+		result, err = c.Resume(ctx)
+	} else {
+		result, err = c.Run(ctx, mainAddress, s)
+	}
+	sess.restore()
+	for _, f := range sess.files {
+		f.Close()
+	}
+
+	finishObservers(tracer, traceOut, profiler, symbols)
+	finish(c, src, sess, result, err)
+}
+
+// checkArgs checks that the program, snapshot, or recording to start from
+//   is given, along with the options that can be used with it.
+func checkArgs() {
+	sources := 0
+	for _, set := range []bool{*restoreFile != "", *replayFile != "", flag.NArg() > 0} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 || (*recordFile != "" && flag.NArg() == 0) {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *replayFile != "" && (*useKeyboard || *keysFile != "" || *diskFile != "") {
+		fmt.Println("error replaying: devices are attached as they were recorded, so -kbd, -keys, and -disk cannot be used")
+		os.Exit(1)
+	}
+}
+
+// startTrace starts tracing the CPU's instructions, if asked to.
+// It also returns the trace file, if any, to be closed once the trace is flushed.
+func startTrace(c *cpu.CPU, symbols svb.Symbols) (*trace.Tracer, *os.File) {
+	if !*useTrace && *traceFile == "" {
+		return nil, nil
+	}
+	var f *os.File
+	w := io.Writer(os.Stderr)
+	if *traceFile != "" {
+		var err error
+		f, err = os.Create(*traceFile)
+		if err != nil {
+			fmt.Println("error creating trace file:", err)
+			os.Exit(1)
+		}
+		w = f
+	}
+	tracer := trace.NewTracer(w)
+	tracer.JSON = *traceJSON
+	tracer.Symbols = symbols
+	for _, s := range traceRanges {
+		r, err := trace.ParseRange(s)
+		if err != nil {
+			fmt.Println("error in trace range:", err)
+			os.Exit(1)
+		}
+		tracer.Ranges = append(tracer.Ranges, r)
+	}
+	for _, name := range traceSubs {
+		sym, ok := symbols.Find(svb.SymbolSubroutine, name)
+		if !ok {
+			fmt.Printf("error in trace subroutine: subroutine \"%s\" not found (see -symbols)\n", name)
+			os.Exit(1)
+		}
+		if sym.Size == 0 {
+			continue
+		}
+		tracer.Ranges = append(tracer.Ranges, trace.Range{Start: sym.Address, End: sym.Address + sym.Size - 1})
+	}
+	c.Observe(tracer)
+	return tracer, f
+}
+
+// attachDevices attaches the devices asked for, or the ones a recording was
+//   made with, and records or replays their inputs.
+// When replaying, s is set to the startup state of the recording.
+func attachDevices(c *cpu.CPU, src *machine.Source, s *cpu.Startup, cancel func()) *session {
+	sess := &session{restore: func() {}}
+
+	// Replay the recorded devices
+	if src.Tape != nil {
+		var err error
+		sess.tape = src.Tape
+		sess.player, sess.devices, err = machine.Replay(c, src.Tape)
+		if err != nil {
+			fmt.Println("error attaching devices:", err)
+			os.Exit(1)
+		}
+		*s = cpu.Startup{Args: src.Tape.Args, Env: src.Tape.Env}
+		return sess
+	}
+
+	// Record inputs
+	if *recordFile != "" {
+		sess.tape = &replay.Tape{
+			Layout:  c.Mem.Layout(),
+			Program: src.Bytes,
+			Args:    s.Args,
+			Env:     s.Env,
+		}
+		sess.recorder = replay.NewRecorder(c, sess.tape)
+	}
+
+	// Timer
+	sess.devices = make(map[string]snapshot.Device)
+	t, err := machine.AttachTimer(c)
+	if err != nil {
+		fmt.Println("error attaching timer:", err)
+		os.Exit(1)
+	}
+	sess.devices["timer"] = t
+	if sess.recorder != nil {
+		sess.recorder.Timer(t)
+	}
+
+	// Drive
+	if *diskFile != "" {
+		d, f, err := machine.AttachDrive(c, *diskFile)
+		if err != nil {
+			fmt.Println("error attaching drive:", err)
+			os.Exit(1)
+		}
+		sess.files = append(sess.files, f)
+		if sess.recorder != nil {
+			sess.recorder.Drive(d)
+		}
+		sess.devices["drive"] = d
+	}
+
+	// Keyboard, reading from a file or the terminal
+	var keys io.Reader
+	var onInterrupt func()
+	if *keysFile != "" {
+		f, err := os.Open(*keysFile)
+		if err != nil {
+			fmt.Println("error opening keys file:", err)
+			os.Exit(1)
+		}
+		sess.files = append(sess.files, f)
+		keys = f
+	} else if *useKeyboard {
+		fd := int(os.Stdin.Fd())
+		if !kbd.IsTerminal(fd) {
+			fmt.Println("error attaching keyboard: stdin is not a terminal")
+			os.Exit(1)
+		}
+		sess.restore, err = kbd.Raw(fd)
+		if err != nil {
+			fmt.Println("error attaching keyboard:", err)
+			os.Exit(1)
+		}
+		keys = os.Stdin
+		onInterrupt = cancel
+
+		// The keyboard consumes the terminal, so the read syscall gets no input
+		c.Stdin = strings.NewReader("")
+	}
+	if keys != nil {
+		k, err := machine.AttachKeyboard(c, onInterrupt)
+		if err != nil {
+			sess.restore()
+			fmt.Println("error attaching keyboard:", err)
+			os.Exit(1)
+		}
+		if sess.recorder != nil {
+			sess.recorder.Keyboard(k)
+		}
+		k.Listen(keys)
+		sess.devices["keyboard"] = k
+	}
+
+	return sess
+}
+
+// finishObservers writes the trace and profile, if any,
+//   and closes the trace file.
+func finishObservers(tracer *trace.Tracer, traceOut *os.File, profiler *profile.Profiler, symbols svb.Symbols) {
+	if tracer != nil {
+		err := tracer.Flush()
+		if traceOut != nil {
+			if cerr := traceOut.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			fmt.Println("error writing trace:", err)
+			os.Exit(1)
+		}
+	}
+	if profiler != nil {
+		prof := profiler.Profile(symbols)
+		if *profileReport {
+			if err := prof.WriteText(os.Stderr, 20); err != nil {
+				fmt.Println("error writing profile report:", err)
+				os.Exit(1)
+			}
+		}
+		if *profileFile != "" {
+			f, err := os.Create(*profileFile)
+			if err == nil {
+				err = prof.WritePprof(f)
+				if cerr := f.Close(); err == nil {
+					err = cerr
+				}
+			}
+			if err != nil {
+				fmt.Println("error writing profile:", err)
+				os.Exit(1)
+			}
+		}
+	}
+}
+
+// finish saves the snapshot and recording, if asked to, reports how
+//   the program stopped, and exits with its exit code.
+func finish(c *cpu.CPU, src *machine.Source, sess *session, result cpu.Result, err error) {
+	if *saveFile != "" {
+		if err := snapshot.WriteFile(*saveFile, snapshot.Take(c, sess.devices)); err != nil {
+			fmt.Println("error saving snapshot:", err)
+			os.Exit(1)
+		}
+	}
+	if sess.recorder != nil {
+		sess.recorder.Finish(result)
+		if err := replay.WriteFile(*recordFile, sess.tape); err != nil {
+			fmt.Println("error saving recording:", err)
+			os.Exit(1)
+		}
+	}
+
+	// Ctrl-C stops the program normally when saving a snapshot or recording
+	if err == context.Canceled && (*saveFile != "" || *recordFile != "") {
+		err = nil
+	} else if sess.player != nil {
+		if err == replay.ErrEnd {
+			err = nil
+		}
+		if err == nil {
+			err = sess.player.Check(result)
+		}
+	}
+	if err != nil {
+		fmt.Println("error running program:", err)
+		if f, ok := err.(*cpu.Fault); ok && !f.Interrupt && src.Program != nil {
+			if lines, lerr := src.Program.Lines(); lerr == nil {
+				if line, ok := lines.Lookup(f.PC); ok {
+					fmt.Println("the fault was caused by", line)
+				}
+			}
+		}
+		os.Exit(1)
+	}
+
+	// Exit statuses only hold 8 bits, so larger codes are clamped
+	code := int(result.ExitCode)
+	if code > 255 {
+		code = 255
+	}
+	os.Exit(code)
 }
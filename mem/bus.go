@@ -0,0 +1,80 @@
+package mem
+
+import (
+	"fmt"
+)
+
+// Device is a memory-mapped peripheral.
+// Registers are addressed by their offset from the start of the device's mapping.
+type Device interface {
+	// Read returns the value of a register.
+	Read(offset uint16) uint16
+	// Write sets the value of a register.
+	Write(offset uint16, value uint16)
+}
+
+// mapping is a device mapped to a range of addresses.
+type mapping struct {
+	start  uint16
+	end    uint16
+	device Device
+}
+
+// Bus routes memory accesses to RAM or to mapped devices.
+type Bus struct {
+	*RAM
+	mappings []mapping
+	// owners maps each address to an index into mappings plus one,
+	//   or zero if the address belongs to RAM.
+	owners [65536]uint8
+}
+
+// NewBus returns a pointer to a newly initialized Bus.
+func NewBus(m *RAM) *Bus {
+	return &Bus{
+		RAM: m,
+	}
+}
+
+// Map maps a device to an inclusive range of addresses.
+func (b *Bus) Map(start uint16, end uint16, d Device) error {
+	if start > end {
+		return fmt.Errorf("invalid address range %x-%x", start, end)
+	}
+	if len(b.mappings) == 255 {
+		return fmt.Errorf("too many devices mapped")
+	}
+	for _, m := range b.mappings {
+		if start <= m.end && end >= m.start {
+			return fmt.Errorf("address range %x-%x overlaps mapped device at %x-%x", start, end, m.start, m.end)
+		}
+	}
+	b.mappings = append(b.mappings, mapping{
+		start:  start,
+		end:    end,
+		device: d,
+	})
+	for a := int(start); a <= int(end); a++ {
+		b.owners[a] = uint8(len(b.mappings))
+	}
+	return nil
+}
+
+// Get gets the value stored at a specified address.
+func (b *Bus) Get(address uint16) uint16 {
+	if o := b.owners[address]; o != 0 {
+		m := b.mappings[o-1]
+		return m.device.Read(address - m.start)
+	}
+	return b.RAM.Mem[address]
+}
+
+// Set sets the specified address to the specified value.
+func (b *Bus) Set(address uint16, value uint16) {
+	if o := b.owners[address]; o != 0 {
+		m := b.mappings[o-1]
+		m.device.Write(address-m.start, value)
+		return
+	}
+	b.RAM.Mem[address] = value
+}
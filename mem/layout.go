@@ -0,0 +1,70 @@
+package mem
+
+import (
+	"fmt"
+)
+
+// Layout describes where each section of memory is.
+type Layout struct {
+	// VGAOffset is the address of the VGA text buffer.
+	VGAOffset uint16 `json:"vga_offset"`
+	// VGAWidth and VGAHeight are the dimensions of the VGA text buffer.
+	VGAWidth  int `json:"vga_width"`
+	VGAHeight int `json:"vga_height"`
+	// StackSize is the number of words in the stack,
+	//   which starts after the VGA text buffer.
+	StackSize uint16 `json:"stack_size"`
+	// ProgramOffset is the address programs are loaded at,
+	//   or zero to load them directly after the stack.
+	ProgramOffset uint16 `json:"program_offset"`
+	// SystemOffset is the address of the reserved system words.
+	SystemOffset uint16 `json:"system_offset"`
+}
+
+// DefaultLayout returns the layout used when none is specified.
+func DefaultLayout() Layout {
+	return Layout{
+		VGAOffset:     0,
+		VGAWidth:      80,
+		VGAHeight:     25,
+		StackSize:     303,
+		ProgramOffset: 0,
+		SystemOffset:  0xffff - SystemSize + 1,
+	}
+}
+
+// Resolved returns the layout with ProgramOffset set to where programs
+//   are actually loaded.
+func (l Layout) Resolved() Layout {
+	if l.ProgramOffset == 0 {
+		l.ProgramOffset = l.VGAOffset + uint16(l.VGAWidth*l.VGAHeight) + l.StackSize + 1
+	}
+	return l
+}
+
+// Validate returns an error if the sections of a layout overlap
+//   or do not fit in memory.
+func (l Layout) Validate() error {
+	if l.VGAWidth < 1 || l.VGAHeight < 1 {
+		return fmt.Errorf("invalid VGA dimensions %dx%d", l.VGAWidth, l.VGAHeight)
+	}
+	if l.StackSize < 1 {
+		return fmt.Errorf("stack size must be at least 1")
+	}
+	stackMin := int(l.VGAOffset) + l.VGAWidth*l.VGAHeight + 1
+	stackMax := stackMin + int(l.StackSize) - 1
+	programOffset := int(l.ProgramOffset)
+	if programOffset == 0 {
+		programOffset = stackMax + 1
+	}
+	if programOffset <= stackMax {
+		return fmt.Errorf("program offset %x overlaps the VGA text buffer or stack (which end at %x)", programOffset, stackMax)
+	}
+	if programOffset >= int(l.SystemOffset) {
+		return fmt.Errorf("program offset %x is not before the system words at %x", programOffset, l.SystemOffset)
+	}
+	if int(l.SystemOffset)+int(SystemSize) > 0x10000 {
+		return fmt.Errorf("system words at %x do not fit in memory", l.SystemOffset)
+	}
+	return nil
+}
@@ -1,8 +1,8 @@
 // Package mem implements memory devices.
 package mem
 
-// AddressSpace maps 16-bit addresses to 16-bit values.
-type AddressSpace map[uint16]uint16
+// AddressSpace holds a 16-bit value for each 16-bit address.
+type AddressSpace [65536]uint16
 
 // RAM represents 128K of word-based memory (64K addresses).
 type RAM struct {
@@ -14,34 +14,87 @@ type RAM struct {
 	StackMax      uint16
 	ProgramOffset uint16
 	HeapOffset    uint16
+	SystemOffset  uint16
 }
 
-// NewRAM returns a pointer to a newly initialized RAM.
+// NewRAM returns a pointer to a newly initialized RAM
+//   using the default layout with the given VGA dimensions.
 func NewRAM(a AddressSpace, vw int, vh int) *RAM {
-	vgaSize := uint16(vh * vw)
+	l := DefaultLayout()
+	l.VGAWidth = vw
+	l.VGAHeight = vh
+	return NewRAMLayout(a, l)
+}
+
+// NewRAMLayout returns a pointer to a newly initialized RAM
+//   using the given layout, which should be valid.
+func NewRAMLayout(a AddressSpace, l Layout) *RAM {
+	l = l.Resolved()
+	stackMin := l.VGAOffset + uint16(l.VGAWidth*l.VGAHeight) + 1
+	stackMax := stackMin + l.StackSize - 1
 	return &RAM{
 		Mem:           a,
-		VGAOffset:     0,
-		VGAHeight:     vh,
-		VGAWidth:      vw,
-		StackMin:      vgaSize + 1,
-		StackMax:      vgaSize + 303,
-		ProgramOffset: vgaSize + 304,
-		HeapOffset:    vgaSize + 304,
+		VGAOffset:     l.VGAOffset,
+		VGAHeight:     l.VGAHeight,
+		VGAWidth:      l.VGAWidth,
+		StackMin:      stackMin,
+		StackMax:      stackMax,
+		ProgramOffset: l.ProgramOffset,
+		HeapOffset:    l.ProgramOffset,
+		SystemOffset:  l.SystemOffset,
+	}
+}
+
+// Layout returns the layout of the RAM.
+func (m *RAM) Layout() Layout {
+	return Layout{
+		VGAOffset:     m.VGAOffset,
+		VGAWidth:      m.VGAWidth,
+		VGAHeight:     m.VGAHeight,
+		StackSize:     m.StackMax - m.StackMin + 1,
+		ProgramOffset: m.ProgramOffset,
+		SystemOffset:  m.SystemOffset,
 	}
 }
 
 // Get gets the value stored at a specified address.
 func (m *RAM) Get(address uint16) uint16 {
-	value, exists := m.Mem[address]
-	if !exists {
-		m.Mem[address] = 0
-		value = 0
-	}
-	return value
+	return m.Mem[address]
 }
 
 // Set sets the specified address to the specified value.
 func (m *RAM) Set(address uint16, value uint16) {
 	m.Mem[address] = value
 }
+
+// System returns the address of a system word.
+func (m *RAM) System(word uint16) uint16 {
+	return m.SystemOffset + word
+}
+
+// Words reserved for system information, as offsets from SystemOffset.
+const (
+	// InterruptVectorWord is the start of the interrupt vector table,
+	//   which holds one handler address for each interrupt line.
+	InterruptVectorWord uint16 = 0x00
+	// KeyboardWord is where the keyboard's registers are mapped.
+	KeyboardWord uint16 = 0x10
+	// DiskWord is where the drive's registers are mapped.
+	DiskWord uint16 = 0x12
+	// TimerWord is where the timer's registers are mapped.
+	TimerWord uint16 = 0x16
+	// FaultVectorWord holds the address of the guest fault handler.
+	FaultVectorWord uint16 = 0x1a
+	// EnvSizeWord holds the size of the environment block.
+	EnvSizeWord uint16 = 0x1b
+	// EnvCountWord holds the number of environment entries.
+	EnvCountWord uint16 = 0x1c
+	// ArgCountWord holds the number of program arguments.
+	ArgCountWord uint16 = 0x1d
+	// ArgSizeWord holds the size of the program arguments.
+	ArgSizeWord uint16 = 0x1e
+	// HeapWord holds the address of the start of the heap.
+	HeapWord uint16 = 0x1f
+	// SystemSize is the number of system words.
+	SystemSize uint16 = 0x20
+)
@@ -0,0 +1,88 @@
+package mem_test
+
+import (
+	"context"
+	"github.com/tteeoo/svc/cpu"
+	"github.com/tteeoo/svc/mem"
+	"github.com/tteeoo/svc/svb"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// sva includes files relative to the working directory
+//go:generate sh -c "cd ../asm && go run ../sva print_int.asm -o ../mem/testdata/print_int.svb"
+//go:generate sh -c "cd ../asm && go run ../sva hello_world.asm -o ../mem/testdata/hello_world.svb"
+
+// sink keeps reads from being optimized away.
+var sink uint16
+
+// BenchmarkRAMGet measures reads across the address space.
+func BenchmarkRAMGet(b *testing.B) {
+	m := mem.NewRAMLayout(mem.AddressSpace{}, mem.DefaultLayout())
+	var v uint16
+	for i := 0; i < b.N; i++ {
+		v += m.Get(uint16(i))
+	}
+	sink = v
+}
+
+// BenchmarkRAMSet measures writes across the address space.
+func BenchmarkRAMSet(b *testing.B) {
+	m := mem.NewRAMLayout(mem.AddressSpace{}, mem.DefaultLayout())
+	for i := 0; i < b.N; i++ {
+		m.Set(uint16(i), uint16(i))
+	}
+}
+
+// BenchmarkRun measures running the example programs in asm, assembled
+//   into testdata, from boot until they return.
+func BenchmarkRun(b *testing.B) {
+	for _, name := range []string{"print_int", "hello_world"} {
+		b.Run(name, func(b *testing.B) {
+			bs, err := ioutil.ReadFile(filepath.Join("testdata", name+".svb"))
+			if err != nil {
+				b.Fatal(err)
+			}
+			f, err := svb.Parse(bs)
+			if err != nil {
+				b.Fatal(err)
+			}
+			layout, ok := f.Layout()
+			if !ok {
+				layout = mem.DefaultLayout()
+			}
+
+			// Load the program once, and copy it for each run
+			m := mem.NewRAMLayout(mem.AddressSpace{}, layout)
+			a, mainAddress, programSize, err := f.Load(cpu.NewCPU(mem.NewBus(m), nil))
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			// Only running is timed, not copying the program
+			cycles := uint64(0)
+			var elapsed time.Duration
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				m := mem.NewRAMLayout(a, layout)
+				m.HeapOffset += programSize
+				c := cpu.NewCPU(mem.NewBus(m), nil)
+				b.StartTimer()
+				start := time.Now()
+				result, err := c.Run(context.Background(), mainAddress, cpu.Startup{})
+				elapsed += time.Since(start)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if result.Reason != cpu.HaltReturn {
+					b.Fatalf("the program stopped with %s", result.Reason)
+				}
+				cycles += result.Cycles
+			}
+			b.ReportMetric(float64(elapsed.Nanoseconds())/float64(cycles), "ns/instr")
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package profile
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// Field numbers of the messages in pprof's profile.proto.
+const (
+	profileSampleType  = 1
+	profileSample      = 2
+	profileMapping     = 3
+	profileLocation    = 4
+	profileFunction    = 5
+	profileStringTable = 6
+	profilePeriodType  = 11
+	profilePeriod      = 12
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	mappingID           = 1
+	mappingMemoryStart  = 2
+	mappingMemoryLimit  = 3
+	mappingFilename     = 5
+	mappingHasFunctions = 7
+
+	locationID        = 1
+	locationMappingID = 2
+	locationAddress   = 3
+	locationLine      = 4
+
+	lineFunctionID = 1
+
+	functionID         = 1
+	functionName       = 2
+	functionSystemName = 3
+)
+
+// message encodes a protocol buffer message.
+type message []byte
+
+// varint appends a varint.
+func (m message) varint(v uint64) message {
+	for v >= 0x80 {
+		m = append(m, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(m, byte(v))
+}
+
+// uint appends a varint field.
+func (m message) uint(field int, v uint64) message {
+	return m.varint(uint64(field) << 3).varint(v)
+}
+
+// bytes appends a length-delimited field.
+func (m message) bytes(field int, b []byte) message {
+	return append(m.varint(uint64(field)<<3|2).varint(uint64(len(b))), b...)
+}
+
+// packed appends a packed repeated varint field.
+func (m message) packed(field int, vs []uint64) message {
+	var p message
+	for _, v := range vs {
+		p = p.varint(v)
+	}
+	return m.bytes(field, p)
+}
+
+// stringTable is a pprof string table.
+type stringTable struct {
+	list  []string
+	index map[string]uint64
+}
+
+// add returns the index of a string, adding it if needed.
+func (s *stringTable) add(str string) uint64 {
+	if i, exists := s.index[str]; exists {
+		return i
+	}
+	i := uint64(len(s.list))
+	s.list = append(s.list, str)
+	s.index[str] = i
+	return i
+}
+
+// WritePprof writes the profile as a gzipped protocol buffer, in the format
+//   read by pprof (go tool pprof).
+// Each subroutine is a function, and each address an instruction executed
+//   at or a call made from is a location.
+func (p *Profile) WritePprof(w io.Writer) error {
+	strs := &stringTable{index: make(map[string]uint64)}
+	strs.add("")
+	var m message
+
+	// Describe values
+	valueType := message{}.
+		uint(valueTypeType, strs.add("instructions")).
+		uint(valueTypeUnit, strs.add("count"))
+	m = m.bytes(profileSampleType, valueType)
+	m = m.bytes(profilePeriodType, valueType)
+	m = m.uint(profilePeriod, 1)
+
+	// Map the address space
+	m = m.bytes(profileMapping, message{}.
+		uint(mappingID, 1).
+		uint(mappingMemoryStart, 0).
+		uint(mappingMemoryLimit, 0x10000).
+		uint(mappingFilename, strs.add("program")).
+		uint(mappingHasFunctions, 1))
+
+	// Add samples, locations, and functions
+	locations := make(map[Frame]uint64)
+	functions := make(map[string]uint64)
+	for _, s := range p.Samples {
+		ids := make([]uint64, len(s.Stack))
+		for i, f := range s.Stack {
+			id, exists := locations[f]
+			if !exists {
+				name := p.Function(f)
+				fid, exists := functions[name]
+				if !exists {
+					fid = uint64(len(functions) + 1)
+					functions[name] = fid
+					m = m.bytes(profileFunction, message{}.
+						uint(functionID, fid).
+						uint(functionName, strs.add(name)).
+						uint(functionSystemName, strs.add(name)))
+				}
+				id = uint64(len(locations) + 1)
+				locations[f] = id
+				m = m.bytes(profileLocation, message{}.
+					uint(locationID, id).
+					uint(locationMappingID, 1).
+					uint(locationAddress, uint64(f.PC)).
+					bytes(locationLine, message{}.uint(lineFunctionID, fid)))
+			}
+			ids[i] = id
+		}
+		m = m.bytes(profileSample, message{}.
+			packed(sampleLocationID, ids).
+			packed(sampleValue, []uint64{s.Count}))
+	}
+
+	// Add strings last, once they are all known
+	for _, str := range strs.list {
+		m = m.bytes(profileStringTable, []byte(str))
+	}
+
+	z := gzip.NewWriter(w)
+	if _, err := z.Write(m); err != nil {
+		return err
+	}
+	return z.Close()
+}
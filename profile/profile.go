@@ -0,0 +1,284 @@
+// Package profile implements counting the instructions executed by the CPU
+//   by address and call stack, and reporting where they were spent.
+package profile
+
+import (
+	"fmt"
+	"github.com/tteeoo/svc/cpu"
+	"github.com/tteeoo/svc/dat"
+	"github.com/tteeoo/svc/svb"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// node is a call stack, stored as a tree of calls.
+type node struct {
+	parent int
+	// site is the address the call was made from, in the parent.
+	site uint16
+	// entry is the address that was called.
+	entry uint16
+}
+
+// call identifies the child of a node.
+type call struct {
+	parent int
+	site   uint16
+	entry  uint16
+}
+
+// frame is a call that has not returned.
+type frame struct {
+	node int
+	// sp is the address of the return address on the stack.
+	sp uint16
+}
+
+// sample identifies an instruction executed with a call stack.
+type sample struct {
+	node int
+	pc   uint16
+}
+
+// Profiler is an observer that counts executed instructions by address
+//   and call stack.
+// Calls and returns are found by watching the stack pointer, so calls
+//   made by any instruction, interrupts, and faults dispatched to a guest
+//   handler are all followed.
+type Profiler struct {
+	cpu.NopObserver
+	nodes    []node
+	children map[call]int
+	frames   []frame
+	counts   map[sample]uint64
+	calls    map[uint16]uint64
+	total    uint64
+	// sp and next are the stack pointer and program counter
+	//   expected before the next instruction.
+	sp   uint16
+	next uint16
+	// before is the stack pointer before the current instruction.
+	before uint16
+}
+
+// NewProfiler returns a pointer to a new Profiler.
+func NewProfiler() *Profiler {
+	return &Profiler{
+		children: make(map[call]int),
+		counts:   make(map[sample]uint64),
+		calls:    make(map[uint16]uint64),
+	}
+}
+
+// BeforeExecute implements cpu.Observer, counting the instruction.
+func (p *Profiler) BeforeExecute(c *cpu.CPU, in cpu.Instruction) {
+	sp := c.Regs[dat.SP]
+	if len(p.nodes) == 0 {
+		p.nodes = append(p.nodes, node{parent: -1, entry: in.PC})
+		p.frames = append(p.frames, frame{node: 0, sp: sp})
+	} else if in.PC != p.next && sp == p.sp-1 {
+		// An interrupt was serviced or a fault dispatched
+		p.push(p.next, in.PC, sp)
+	}
+	p.counts[sample{node: p.frames[len(p.frames)-1].node, pc: in.PC}]++
+	p.total++
+	p.before = sp
+	p.next = c.Regs[dat.PC]
+}
+
+// AfterExecute implements cpu.Observer, following calls and returns.
+func (p *Profiler) AfterExecute(c *cpu.CPU, in cpu.Instruction, err error) {
+	sp := c.Regs[dat.SP]
+	pc := c.Regs[dat.PC]
+	if pc != p.next {
+		if sp == p.before-1 && c.Mem.Get(sp) == p.next {
+			p.push(in.PC, pc, sp)
+		} else if sp == p.before+1 && c.Mem.Get(sp-1) == pc {
+			p.pop(sp)
+		}
+	}
+	p.sp = sp
+	p.next = pc
+}
+
+// push enters a call made from site to entry, with the return address at sp.
+func (p *Profiler) push(site, entry, sp uint16) {
+	parent := p.frames[len(p.frames)-1].node
+	k := call{parent: parent, site: site, entry: entry}
+	n, exists := p.children[k]
+	if !exists {
+		n = len(p.nodes)
+		p.nodes = append(p.nodes, node{parent: parent, site: site, entry: entry})
+		p.children[k] = n
+	}
+	p.frames = append(p.frames, frame{node: n, sp: sp})
+	p.calls[entry]++
+}
+
+// pop leaves the calls whose return addresses are below sp.
+func (p *Profiler) pop(sp uint16) {
+	for len(p.frames) > 1 && p.frames[len(p.frames)-1].sp < sp {
+		p.frames = p.frames[:len(p.frames)-1]
+	}
+}
+
+// Frame is an address in a call stack.
+type Frame struct {
+	// PC is the address of the executed instruction, or of the call.
+	PC uint16
+	// Entry is the address the subroutine holding PC was entered at.
+	Entry uint16
+}
+
+// Sample is a number of instructions executed with the same call stack.
+type Sample struct {
+	// Stack holds the executed instruction, followed by the calls
+	//   that led to it, innermost first.
+	Stack []Frame
+	Count uint64
+}
+
+// Profile holds the counts collected by a Profiler.
+type Profile struct {
+	// Symbols, if set, are used to name subroutines.
+	Symbols svb.Symbols
+	// Total is the number of executed instructions.
+	Total   uint64
+	Samples []Sample
+	// Calls maps the addresses that were called to the number of calls.
+	Calls map[uint16]uint64
+}
+
+// Profile returns the counts collected so far.
+func (p *Profiler) Profile(symbols svb.Symbols) *Profile {
+	prof := &Profile{
+		Symbols: symbols,
+		Total:   p.total,
+		Calls:   make(map[uint16]uint64),
+	}
+	for s, count := range p.counts {
+		stack := []Frame{{PC: s.pc, Entry: p.nodes[s.node].entry}}
+		for n := s.node; p.nodes[n].parent >= 0; n = p.nodes[n].parent {
+			stack = append(stack, Frame{PC: p.nodes[n].site, Entry: p.nodes[p.nodes[n].parent].entry})
+		}
+		prof.Samples = append(prof.Samples, Sample{Stack: stack, Count: count})
+	}
+	sort.Slice(prof.Samples, func(i, j int) bool { return prof.Samples[i].Count > prof.Samples[j].Count })
+	for entry, count := range p.calls {
+		prof.Calls[entry] = count
+	}
+	return prof
+}
+
+// Function returns the name of the subroutine holding a frame: the symbol
+//   covering its address, or the hex address the subroutine was entered at.
+func (p *Profile) Function(f Frame) string {
+	if s, ok := p.Symbols.Lookup(f.PC); ok {
+		return s.Name
+	}
+	return fmt.Sprintf("%04x", f.Entry)
+}
+
+// Subroutine holds the counts of a subroutine.
+type Subroutine struct {
+	Name string
+	// Calls is the number of times the subroutine was called.
+	Calls uint64
+	// Exclusive is the number of instructions executed in the subroutine.
+	Exclusive uint64
+	// Inclusive also counts the instructions executed in the subroutines
+	//   it called.
+	Inclusive uint64
+}
+
+// Subroutines returns the counts of each subroutine, most exclusive first.
+func (p *Profile) Subroutines() []Subroutine {
+	subs := make(map[string]*Subroutine)
+	get := func(name string) *Subroutine {
+		if subs[name] == nil {
+			subs[name] = &Subroutine{Name: name}
+		}
+		return subs[name]
+	}
+	for _, s := range p.Samples {
+		get(p.Function(s.Stack[0])).Exclusive += s.Count
+		seen := make(map[string]bool)
+		for _, f := range s.Stack {
+			name := p.Function(f)
+			if !seen[name] {
+				seen[name] = true
+				get(name).Inclusive += s.Count
+			}
+		}
+	}
+	for entry, count := range p.Calls {
+		get(p.Function(Frame{PC: entry, Entry: entry})).Calls += count
+	}
+
+	list := []Subroutine{}
+	for _, s := range subs {
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Exclusive != list[j].Exclusive {
+			return list[i].Exclusive > list[j].Exclusive
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Instruction holds the number of times the instruction at an address
+//   was executed.
+type Instruction struct {
+	Address uint16
+	Count   uint64
+}
+
+// Instructions returns the number of times each address was executed,
+//   most executed first.
+func (p *Profile) Instructions() []Instruction {
+	counts := make(map[uint16]uint64)
+	for _, s := range p.Samples {
+		counts[s.Stack[0].PC] += s.Count
+	}
+	list := []Instruction{}
+	for a, count := range counts {
+		list = append(list, Instruction{Address: a, Count: count})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Address < list[j].Address
+	})
+	return list
+}
+
+// WriteText writes a report of the subroutines, and of the top most
+//   executed instructions, as text tables.
+func (p *Profile) WriteText(w io.Writer, top int) error {
+	percent := func(n uint64) float64 {
+		if p.Total == 0 {
+			return 0
+		}
+		return float64(n) * 100 / float64(p.Total)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "instructions executed: %d\n\n", p.Total)
+	fmt.Fprintf(tw, "subroutine\tcalls\texclusive\t%%\tinclusive\t%%\n")
+	for _, s := range p.Subroutines() {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.2f%%\t%d\t%.2f%%\n",
+			s.Name, s.Calls, s.Exclusive, percent(s.Exclusive), s.Inclusive, percent(s.Inclusive))
+	}
+	fmt.Fprintf(tw, "\naddress\tsymbol\tcount\t%%\n")
+	for i, in := range p.Instructions() {
+		if i == top {
+			break
+		}
+		fmt.Fprintf(tw, "%04x\t%s\t%d\t%.2f%%\n", in.Address, p.Symbols.Describe(in.Address), in.Count, percent(in.Count))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,50 @@
+package profile_test
+
+import (
+	"context"
+	"github.com/tteeoo/svc/cpu"
+	"github.com/tteeoo/svc/machine"
+	"github.com/tteeoo/svc/mem"
+	"github.com/tteeoo/svc/profile"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+//go:generate go run ../sva testdata/nested.asm -o testdata/nested.svb
+
+// TestNestedCalls checks the exclusive and inclusive counts of subroutines
+//   calling each other.
+func TestNestedCalls(t *testing.T) {
+	src, err := machine.Open(filepath.Join("testdata", "nested.svb"), "", "", mem.DefaultLayout(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cpu.NewCPU(mem.NewBus(mem.NewRAMLayout(mem.AddressSpace{}, src.Layout)), nil)
+	mainAddress, err := src.Load(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	symbols, err := src.Symbols("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := profile.NewProfiler()
+	c.Observe(p)
+	if _, err := c.Run(context.Background(), mainAddress, cpu.Startup{}); err != nil {
+		t.Fatal(err)
+	}
+
+	prof := p.Profile(symbols)
+	if prof.Total != 12 {
+		t.Errorf("total %d, want 12", prof.Total)
+	}
+	want := []profile.Subroutine{
+		{Name: "inner", Calls: 2, Exclusive: 6, Inclusive: 6},
+		{Name: "outer", Calls: 1, Exclusive: 4, Inclusive: 10},
+		{Name: "main", Calls: 0, Exclusive: 2, Inclusive: 12},
+	}
+	if got := prof.Subroutines(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got subroutines %+v, want %+v", got, want)
+	}
+}
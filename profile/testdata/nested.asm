@@ -0,0 +1,17 @@
+; Calls outer, which calls inner twice, to test counting the instructions
+;   executed in nested calls.
+
+inner:
+  inc ra
+  inc ra
+  ret
+
+outer:
+  inc rb
+  cal {inner}
+  cal {inner}
+  ret
+
+main:
+  cal {outer}
+  ret
@@ -0,0 +1,176 @@
+package replay
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tteeoo/svc/cpu"
+	"github.com/tteeoo/svc/dat"
+	"github.com/tteeoo/svc/disk"
+	"github.com/tteeoo/svc/kbd"
+	"github.com/tteeoo/svc/timer"
+)
+
+// ErrEnd is returned by CPU.Poll when a recording that was canceled
+//   has been replayed up to where it stopped.
+var ErrEnd = errors.New("end of recording")
+
+// Player delivers the inputs recorded on a tape to a CPU.
+// The CPU should be booted with the program and startup state of the tape,
+//   and have the same devices attached.
+type Player struct {
+	tape     *Tape
+	c        *cpu.CPU
+	keyboard *kbd.Keyboard
+	timer    *timer.Timer
+	// next is the index of the next event to deliver.
+	next int
+	// err is set when execution diverges from the recording.
+	err error
+}
+
+// NewPlayer returns a pointer to a new Player replaying t.
+// It sets c.Poll and replaces the syscalls recorded on t.
+func NewPlayer(c *cpu.CPU, t *Tape) *Player {
+	p := &Player{
+		tape: t,
+		c:    c,
+	}
+	c.Poll = p.poll
+	for _, n := range t.Syscalls {
+		c.RegisterSyscall(n, p.syscall(n))
+	}
+	return p
+}
+
+// Keyboard makes the recorded keys available from k.
+// Keys should not be listened for.
+func (p *Player) Keyboard(k *kbd.Keyboard) {
+	p.keyboard = k
+}
+
+// Timer makes t count the recorded milliseconds, instead of real ones.
+func (p *Player) Timer(t *timer.Timer) {
+	p.timer = t
+	t.OnTick = func() {}
+}
+
+// Drive makes reads from d return the recorded data.
+// Writes are discarded, so no image is needed.
+func (p *Player) Drive(d *disk.Drive) {
+	d.Image = &playedImage{p: p}
+}
+
+// Check returns an error if execution did not stop the same way
+//   as it did when recorded.
+func (p *Player) Check(result cpu.Result) error {
+	end := p.tape.End
+	if result.Reason != end.Reason || result.Cycles != end.Cycles || result.Regs != end.Regs || result.ExitCode != end.ExitCode {
+		return fmt.Errorf(
+			"replay diverged from the recording: stopped (%s) at cycle %d with pc %x, but the recording stopped (%s) at cycle %d with pc %x",
+			result.Reason, result.Cycles, result.Regs[dat.PC], end.Reason, end.Cycles, end.Regs[dat.PC],
+		)
+	}
+	return nil
+}
+
+// poll delivers the keys and ticks recorded for the current cycle.
+func (p *Player) poll(c *cpu.CPU) error {
+	if p.err != nil {
+		return p.err
+	}
+	for ; p.next < len(p.tape.Events); p.next++ {
+		e := &p.tape.Events[p.next]
+		if e.Cycle > c.Cycles {
+			return nil
+		}
+		if e.Cycle < c.Cycles {
+			return p.diverged()
+		}
+		switch {
+		case e.Kind == EventKey && p.keyboard != nil:
+			p.keyboard.Push(e.Value)
+		case e.Kind == EventTick && p.timer != nil:
+			for i := uint16(0); i < e.Value; i++ {
+				p.timer.Tick()
+			}
+		default:
+			return p.diverged()
+		}
+	}
+
+	// Stop where a canceled recording stopped
+	if c.Cycles >= p.tape.End.Cycles && p.tape.End.Reason == cpu.HaltCanceled {
+		return ErrEnd
+	}
+	return nil
+}
+
+// take returns the next event, which must be of kind k and recorded
+//   during the current cycle.
+func (p *Player) take(k Kind) (*Event, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	if p.next >= len(p.tape.Events) {
+		return nil, p.diverged()
+	}
+	e := &p.tape.Events[p.next]
+	if e.Kind != k || e.Cycle != p.c.Cycles {
+		return nil, p.diverged()
+	}
+	p.next++
+	return e, nil
+}
+
+// diverged stops replaying, returning the error returned from then on.
+func (p *Player) diverged() error {
+	p.err = fmt.Errorf("replay diverged from the recording at cycle %d", p.c.Cycles)
+	return p.err
+}
+
+// syscall returns a syscall that makes the changes recorded for syscall n.
+func (p *Player) syscall(n uint16) cpu.Syscall {
+	return func(c *cpu.CPU) error {
+		e, err := p.take(EventSyscall)
+		if err != nil {
+			return err
+		}
+		if e.Value != n {
+			return p.diverged()
+		}
+		for _, w := range e.Changes {
+			if w.Register {
+				c.SetReg(w.Address%dat.RegNum, w.Value)
+			} else {
+				c.Store(w.Address, w.Value)
+			}
+		}
+		if e.Err != "" {
+			return errors.New(e.Err)
+		}
+		return nil
+	}
+}
+
+// playedImage is a drive image whose reads return recorded data.
+type playedImage struct {
+	p *Player
+}
+
+// ReadAt implements io.ReaderAt.
+func (i *playedImage) ReadAt(b []byte, off int64) (int, error) {
+	e, err := i.p.take(EventRead)
+	if err != nil {
+		return 0, err
+	}
+	n := copy(b, e.Data)
+	if e.Err != "" {
+		return n, errors.New(e.Err)
+	}
+	return n, nil
+}
+
+// WriteAt implements io.WriterAt.
+func (i *playedImage) WriteAt(b []byte, off int64) (int, error) {
+	return len(b), nil
+}
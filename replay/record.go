@@ -0,0 +1,162 @@
+package replay
+
+import (
+	"github.com/tteeoo/svc/cpu"
+	"github.com/tteeoo/svc/disk"
+	"github.com/tteeoo/svc/kbd"
+	"github.com/tteeoo/svc/timer"
+	"sync"
+)
+
+// Recorder records the inputs delivered to a CPU onto a tape.
+// Keys and timer ticks arriving from other goroutines are queued, and
+//   delivered by CPU.Poll, so they are stamped with the cycle they take
+//   effect on.
+type Recorder struct {
+	cpu.NopObserver
+	tape     *Tape
+	c        *cpu.CPU
+	keyboard *kbd.Keyboard
+	timer    *timer.Timer
+	// changes collects the writes made by a recorded syscall while it runs.
+	changes   []Change
+	capturing bool
+	mu        sync.Mutex
+	queue     []Event
+}
+
+// NewRecorder returns a pointer to a new Recorder recording onto t,
+//   which should already hold the program and its startup state.
+// It sets c.Poll, adds itself to c as an observer, and wraps the
+//   syscalls in Syscalls.
+func NewRecorder(c *cpu.CPU, t *Tape) *Recorder {
+	r := &Recorder{
+		tape: t,
+		c:    c,
+	}
+	c.Poll = r.poll
+	c.Observe(r)
+	t.Syscalls = nil
+	for _, n := range Syscalls {
+		if s, exists := c.Syscalls[n]; exists {
+			c.RegisterSyscall(n, r.syscall(n, s))
+			t.Syscalls = append(t.Syscalls, n)
+		}
+	}
+	return r
+}
+
+// Keyboard records the keys listened for by k.
+func (r *Recorder) Keyboard(k *kbd.Keyboard) {
+	r.keyboard = k
+	r.tape.Keyboard = true
+	k.OnKey = func(key uint16) {
+		r.enqueue(Event{Kind: EventKey, Value: key})
+	}
+}
+
+// Timer records the milliseconds counted by t.
+func (r *Recorder) Timer(t *timer.Timer) {
+	r.timer = t
+	t.OnTick = func() {
+		r.enqueue(Event{Kind: EventTick, Value: 1})
+	}
+}
+
+// Drive records the reads from the image backing d.
+func (r *Recorder) Drive(d *disk.Drive) {
+	r.tape.Sectors = d.Sectors
+	d.Image = &recordedImage{r: r, img: d.Image}
+}
+
+// Finish records how execution stopped.
+func (r *Recorder) Finish(result cpu.Result) {
+	r.tape.End = result
+}
+
+// MemoryWrite implements cpu.Observer, collecting the writes of syscalls.
+func (r *Recorder) MemoryWrite(address, value uint16) {
+	if r.capturing {
+		r.changes = append(r.changes, Change{Address: address, Value: value})
+	}
+}
+
+// RegisterWrite implements cpu.Observer, collecting the writes of syscalls.
+func (r *Recorder) RegisterWrite(register, value uint16) {
+	if r.capturing {
+		r.changes = append(r.changes, Change{Register: true, Address: register, Value: value})
+	}
+}
+
+// enqueue queues an input until it is delivered by poll,
+//   waking the CPU if it is waiting for interrupts.
+func (r *Recorder) enqueue(e Event) {
+	r.mu.Lock()
+	last := len(r.queue) - 1
+	if e.Kind == EventTick && last >= 0 && r.queue[last].Kind == EventTick && r.queue[last].Value < 0xffff {
+		r.queue[last].Value++
+	} else {
+		r.queue = append(r.queue, e)
+	}
+	r.mu.Unlock()
+	r.c.IRQ.Wake()
+}
+
+// poll delivers and records the queued inputs.
+func (r *Recorder) poll(c *cpu.CPU) error {
+	r.mu.Lock()
+	queue := r.queue
+	r.queue = nil
+	r.mu.Unlock()
+	for _, e := range queue {
+		e.Cycle = c.Cycles
+		r.tape.Events = append(r.tape.Events, e)
+		switch e.Kind {
+		case EventKey:
+			r.keyboard.Push(e.Value)
+		case EventTick:
+			for i := uint16(0); i < e.Value; i++ {
+				r.timer.Tick()
+			}
+		}
+	}
+	return nil
+}
+
+// syscall returns a syscall that calls s, recording its writes.
+func (r *Recorder) syscall(n uint16, s cpu.Syscall) cpu.Syscall {
+	return func(c *cpu.CPU) error {
+		r.changes = nil
+		r.capturing = true
+		err := s(c)
+		r.capturing = false
+		e := Event{Cycle: c.Cycles, Kind: EventSyscall, Value: n, Changes: r.changes}
+		if err != nil {
+			e.Err = err.Error()
+		}
+		r.tape.Events = append(r.tape.Events, e)
+		return err
+	}
+}
+
+// recordedImage is a drive image whose reads are recorded.
+type recordedImage struct {
+	r   *Recorder
+	img disk.Image
+}
+
+// ReadAt implements io.ReaderAt.
+func (i *recordedImage) ReadAt(b []byte, off int64) (int, error) {
+	n, err := i.img.ReadAt(b, off)
+	e := Event{Cycle: i.r.c.Cycles, Kind: EventRead, Data: append([]byte{}, b[:n]...)}
+	if err != nil {
+		e.Err = err.Error()
+	}
+	i.r.tape.Events = append(i.r.tape.Events, e)
+	return n, err
+}
+
+// WriteAt implements io.WriterAt.
+func (i *recordedImage) WriteAt(b []byte, off int64) (int, error) {
+	return i.img.WriteAt(b, off)
+}
@@ -0,0 +1,121 @@
+package replay_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/tteeoo/svc/cpu"
+	"github.com/tteeoo/svc/machine"
+	"github.com/tteeoo/svc/mem"
+	"github.com/tteeoo/svc/replay"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//go:generate go run ../sva testdata/echo.asm -o testdata/echo.svb
+
+// writes is an observer listing the register and memory writes it sees.
+type writes struct {
+	cpu.NopObserver
+	list []string
+}
+
+func (w *writes) RegisterWrite(register, value uint16) {
+	w.list = append(w.list, fmt.Sprintf("r%d=%x", register, value))
+}
+
+func (w *writes) MemoryWrite(address, value uint16) {
+	w.list = append(w.list, fmt.Sprintf("%x=%x", address, value))
+}
+
+// run runs a source on a new CPU, recording it, or replaying it if it
+//   has a tape. It returns how it stopped, what the program wrote to
+//   stdout, the writes observed, and the tape or the player.
+func run(t *testing.T, src *machine.Source, stdin string) (cpu.Result, string, []string, *replay.Tape, *replay.Player) {
+	m := mem.NewRAMLayout(mem.AddressSpace{}, src.Layout)
+	c := cpu.NewCPU(mem.NewBus(m), nil)
+	mainAddress, err := src.Load(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout bytes.Buffer
+	c.Stdin = strings.NewReader(stdin)
+	c.Stdout = &stdout
+	w := &writes{}
+	c.Observe(w)
+
+	var tape *replay.Tape
+	var recorder *replay.Recorder
+	var player *replay.Player
+	var s cpu.Startup
+	if src.Tape != nil {
+		player, _, err = machine.Replay(c, src.Tape)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s = cpu.Startup{Args: src.Tape.Args, Env: src.Tape.Env}
+	} else {
+		s = cpu.Startup{Args: []string{"arg"}}
+		tape = &replay.Tape{Layout: c.Mem.Layout(), Program: src.Bytes, Args: s.Args}
+		recorder = replay.NewRecorder(c, tape)
+		timer, err := machine.AttachTimer(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder.Timer(timer)
+	}
+
+	result, err := c.Run(context.Background(), mainAddress, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorder != nil {
+		recorder.Finish(result)
+	}
+	return result, stdout.String(), w.list, tape, player
+}
+
+// TestRecordReplay records a program using each recorded syscall, and
+//   checks that replaying the tape, after writing and reading it back,
+//   ends the same way with the same output and the same observed writes.
+func TestRecordReplay(t *testing.T) {
+	src, err := machine.Open(filepath.Join("testdata", "echo.svb"), "", "", mem.DefaultLayout(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, out, recorded, tape, _ := run(t, src, "hello")
+	if result.Reason != cpu.HaltReturn || out != "hello" {
+		t.Fatalf("recording stopped with %s and printed %q", result.Reason, out)
+	}
+
+	// Write the tape and read it back
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "echo.tape")
+	if err := replay.WriteFile(name, tape); err != nil {
+		t.Fatal(err)
+	}
+	src, err = machine.Open("", "", name, mem.DefaultLayout(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Replay with no input, which comes from the tape instead
+	result, out, replayed, _, player := run(t, src, "")
+	if err := player.Check(result); err != nil {
+		t.Error(err)
+	}
+	if out != "hello" {
+		t.Errorf("replay printed %q, want %q", out, "hello")
+	}
+	if !reflect.DeepEqual(replayed, recorded) {
+		t.Errorf("replay observed %d writes that differ from the %d recorded", len(replayed), len(recorded))
+	}
+}
@@ -0,0 +1,159 @@
+// Package replay implements recording the nondeterministic inputs of
+//   a virtual machine, and replaying them to reproduce a run exactly.
+package replay
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/tteeoo/svc/cpu"
+	"github.com/tteeoo/svc/disk"
+	"github.com/tteeoo/svc/kbd"
+	"github.com/tteeoo/svc/mem"
+	"github.com/tteeoo/svc/timer"
+	"io"
+	"os"
+)
+
+// Magic starts every tape file.
+const Magic = "SVCTAPE"
+
+// Version is the version of the tape format written by Write.
+const Version uint16 = 1
+
+// Syscalls lists the default syscalls whose results depend on the host,
+//   and are recorded.
+var Syscalls = []uint16{cpu.SysRead, cpu.SysTime, cpu.SysRandom}
+
+// Kind is the kind of an Event.
+type Kind uint8
+
+const (
+	// EventKey is a key made available by the keyboard.
+	EventKey Kind = iota
+	// EventTick is a number of milliseconds counted by the timer.
+	EventTick
+	// EventRead is a read from the image backing the drive.
+	EventRead
+	// EventSyscall is a call to a recorded syscall.
+	EventSyscall
+)
+
+// Change is a register or memory write made by a syscall.
+type Change struct {
+	// Register is true if Address is a register number.
+	Register bool
+	Address  uint16
+	Value    uint16
+}
+
+// Event is a recorded input.
+type Event struct {
+	// Cycle is the number of instructions executed when the input was delivered.
+	Cycle uint64
+	Kind  Kind
+	// Value is the key, the number of ticks, or the syscall number.
+	Value uint16
+	// Data holds the bytes read from the drive image.
+	Data []byte
+	// Changes holds the writes made by the syscall.
+	Changes []Change
+	// Err is the error returned by the read or syscall, if any.
+	Err string
+}
+
+// Tape holds a program, its startup state, and the inputs delivered to it
+//   while it ran.
+type Tape struct {
+	// Layout is the memory layout the program ran with.
+	Layout mem.Layout
+	// Program is the svb program that ran.
+	Program []byte
+	// Args and Env are passed to the program when it is booted.
+	Args []string
+	Env  []string
+	// Keyboard is true if a keyboard was attached.
+	Keyboard bool
+	// Sectors is the number of sectors in the drive image, or 0 if no drive was attached.
+	Sectors uint16
+	// Syscalls lists the syscalls whose results were recorded.
+	Syscalls []uint16
+	// Events holds the recorded inputs, in the order they were delivered.
+	Events []Event
+	// End describes how execution stopped.
+	End cpu.Result
+}
+
+// Inputs is implemented by Recorder and Player.
+// Devices passed to it have their inputs recorded or replayed.
+type Inputs interface {
+	Keyboard(k *kbd.Keyboard)
+	Timer(t *timer.Timer)
+	Drive(d *disk.Drive)
+}
+
+// Write writes a tape to w.
+func Write(w io.Writer, t *Tape) error {
+	if _, err := io.WriteString(w, Magic); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, Version); err != nil {
+		return err
+	}
+	z := gzip.NewWriter(w)
+	if err := gob.NewEncoder(z).Encode(t); err != nil {
+		return err
+	}
+	return z.Close()
+}
+
+// Read reads a tape from r.
+func Read(r io.Reader) (*Tape, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != Magic {
+		return nil, errors.New("not a tape file")
+	}
+	var version uint16
+	if err := binary.Read(br, binary.BigEndian, &version); err != nil {
+		return nil, errors.New("not a tape file")
+	}
+	if version != Version {
+		return nil, fmt.Errorf("unsupported tape version %d", version)
+	}
+	z, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tape{}
+	if err := gob.NewDecoder(z).Decode(t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ReadFile reads a tape from a file.
+func ReadFile(name string) (*Tape, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// WriteFile writes a tape to a file, creating or truncating it.
+func WriteFile(name string, t *Tape) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := Write(f, t); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,19 @@
+; Echoes its input, then gets a random word and the time, so each of the
+;   recorded syscalls is used.
+
+main:
+
+  ; Read up to 16 bytes into the heap.
+  ldr ra (0xffff)
+  cpl rb 16
+  sys 1
+
+  ; Write them back.
+  cop rb ac
+  sys 0
+
+  ; Keep a random word, and get the time.
+  sys 4
+  cop rc ac
+  sys 3
+  ret
@@ -0,0 +1,157 @@
+// Package snapshot implements saving and restoring the complete state
+//   of a virtual machine.
+package snapshot
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/tteeoo/svc/cpu"
+	"github.com/tteeoo/svc/mem"
+	"io"
+	"os"
+)
+
+// Magic starts every snapshot file.
+const Magic = "SVCSNAP"
+
+// Version is the version of the snapshot format written by Write.
+const Version uint16 = 1
+
+// Device is a device whose state can be saved in a snapshot.
+type Device interface {
+	SaveState() []uint16
+	LoadState(state []uint16) error
+}
+
+// Snapshot holds the state of a virtual machine.
+type Snapshot struct {
+	// RAM holds the contents of memory and the memory layout.
+	RAM mem.RAM
+	// CPU holds the registers, cycle count, and interrupt state.
+	CPU cpu.State
+	// VGA holds the last buffer drawn by the VGA device, if there is one.
+	VGA []string
+	// Devices maps names to the states of devices.
+	Devices map[string][]uint16
+}
+
+// Take returns a snapshot of a CPU, its memory and VGA device,
+//   and the given devices.
+func Take(c *cpu.CPU, devices map[string]Device) *Snapshot {
+	s := &Snapshot{
+		RAM:     *c.Mem.RAM,
+		CPU:     c.State(),
+		Devices: make(map[string][]uint16),
+	}
+	if c.VGA != nil {
+		s.VGA = append([]string{}, c.VGA.LastBuffer...)
+	}
+	for name, d := range devices {
+		s.Devices[name] = d.SaveState()
+	}
+	return s
+}
+
+// Restore restores a snapshot into a CPU, its memory and VGA device,
+//   and the given devices.
+// The CPU's memory should use the same layout as the snapshot (see Layout),
+//   since devices are mapped at addresses depending on it.
+// Devices that are not in the snapshot are left as they are, but every
+//   device in the snapshot must be given, so its state is not lost.
+func (s *Snapshot) Restore(c *cpu.CPU, devices map[string]Device) error {
+	if c.Mem.RAM.Layout() != s.Layout() {
+		return errors.New("the memory layout does not match the snapshot")
+	}
+	for name := range s.Devices {
+		if _, exists := devices[name]; !exists {
+			return fmt.Errorf("the snapshot holds the state of a %s, which is not attached", name)
+		}
+	}
+	for name, d := range devices {
+		state, exists := s.Devices[name]
+		if !exists {
+			continue
+		}
+		if err := d.LoadState(state); err != nil {
+			return fmt.Errorf("restoring %s: %w", name, err)
+		}
+	}
+	*c.Mem.RAM = s.RAM
+	c.SetState(s.CPU)
+	if c.VGA != nil {
+		c.VGA.LastBuffer = append([]string{}, s.VGA...)
+	}
+	return nil
+}
+
+// Layout returns the memory layout of the snapshot.
+func (s *Snapshot) Layout() mem.Layout {
+	return s.RAM.Layout()
+}
+
+// Write writes a snapshot to w.
+func Write(w io.Writer, s *Snapshot) error {
+	if _, err := io.WriteString(w, Magic); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, Version); err != nil {
+		return err
+	}
+	z := gzip.NewWriter(w)
+	if err := gob.NewEncoder(z).Encode(s); err != nil {
+		return err
+	}
+	return z.Close()
+}
+
+// Read reads a snapshot from r.
+func Read(r io.Reader) (*Snapshot, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != Magic {
+		return nil, errors.New("not a snapshot file")
+	}
+	var version uint16
+	if err := binary.Read(br, binary.BigEndian, &version); err != nil {
+		return nil, errors.New("not a snapshot file")
+	}
+	if version != Version {
+		return nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+	z, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{}
+	if err := gob.NewDecoder(z).Decode(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ReadFile reads a snapshot from a file.
+func ReadFile(name string) (*Snapshot, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// WriteFile writes a snapshot to a file, creating or truncating it.
+func WriteFile(name string, s *Snapshot) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := Write(f, s); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
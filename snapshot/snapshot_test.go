@@ -0,0 +1,83 @@
+package snapshot_test
+
+import (
+	"github.com/tteeoo/svc/cpu"
+	"github.com/tteeoo/svc/dat"
+	"github.com/tteeoo/svc/mem"
+	"github.com/tteeoo/svc/snapshot"
+	"github.com/tteeoo/svc/timer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// newMachine returns a CPU with a timer attached.
+func newMachine() (*cpu.CPU, map[string]snapshot.Device) {
+	m := mem.NewRAMLayout(mem.AddressSpace{}, mem.DefaultLayout())
+	c := cpu.NewCPU(mem.NewBus(m), nil)
+	return c, map[string]snapshot.Device{"timer": timer.NewTimer(c.IRQ)}
+}
+
+// TestRoundTrip takes a snapshot, writes it to a file, reads it back,
+//   and restores it into another machine.
+func TestRoundTrip(t *testing.T) {
+	c, devices := newMachine()
+	c.Regs[dat.RA] = 0x1234
+	c.Regs[dat.PC] = 0x0940
+	c.Cycles = 99
+	c.Mem.Set(0x2000, 0xbeef)
+	c.IRQ.Raise(3)
+	tm := devices["timer"].(*timer.Timer)
+	tm.Write(timer.ReloadRegister, 50)
+	tm.Write(timer.ControlRegister, timer.ControlEnable|timer.ControlPeriodic)
+	tm.Tick()
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "snap")
+	if err := snapshot.WriteFile(name, snapshot.Take(c, devices)); err != nil {
+		t.Fatal(err)
+	}
+	s, err := snapshot.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, restoredDevices := newMachine()
+	if err := s.Restore(restored, restoredDevices); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Regs != c.Regs || restored.Cycles != c.Cycles {
+		t.Errorf("registers %v at cycle %d, want %v at cycle %d", restored.Regs, restored.Cycles, c.Regs, c.Cycles)
+	}
+	if v := restored.Mem.Get(0x2000); v != 0xbeef {
+		t.Errorf("memory holds %04x, want beef", v)
+	}
+	if restored.IRQ.Pending() != c.IRQ.Pending() {
+		t.Errorf("pending interrupts %x, want %x", restored.IRQ.Pending(), c.IRQ.Pending())
+	}
+	if got, want := restoredDevices["timer"].SaveState(), tm.SaveState(); !reflect.DeepEqual(got, want) {
+		t.Errorf("timer state %v, want %v", got, want)
+	}
+}
+
+// TestRestoreMissingDevice checks that a snapshot holding the state of
+//   a device that is not attached is not restored.
+func TestRestoreMissingDevice(t *testing.T) {
+	c, devices := newMachine()
+	c.Regs[dat.RA] = 0x1234
+	s := snapshot.Take(c, devices)
+
+	restored, _ := newMachine()
+	if err := s.Restore(restored, nil); err == nil {
+		t.Fatal("Restore returned no error")
+	}
+	if restored.Regs[dat.RA] != 0 {
+		t.Errorf("Restore changed the registers before returning an error")
+	}
+}
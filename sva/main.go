@@ -1,9 +1,11 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/tteeoo/svc/cpu"
 	"github.com/tteeoo/svc/mem"
+	"github.com/tteeoo/svc/util"
 	"github.com/tteeoo/svc/vga"
 	"io/ioutil"
 	"os"
@@ -11,31 +13,30 @@ import (
 
 func main() {
 
-	// Get input file
-	if len(os.Args) < 2 {
-		fmt.Printf("run like this: %s <input file> [-o <output file>] [-p]\n", os.Args[0])
+	// Parse flags, which may come before or after the input file
+	outputFile := flag.String("o", "./out.svb", "output file")
+	writePP := flag.Bool("p", false, "write the pre-processed assembly to <output file>.asm")
+	writeSymbols := flag.Bool("sym", false, "write the symbol table to <output file>.sym")
+	layoutFlags := util.LayoutFlags()
+	flag.Usage = func() {
+		fmt.Printf("run like this: %s <input file> [-o <output file>] [-p] [-sym] [layout options]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
 		os.Exit(1)
 	}
-
-	// Determine if we should output the pre-processed assembly
-	writePP := false
-	var args []string
-	for _, a := range os.Args[1:] {
-		if a != "-p" {
-			args = append(args, a)
-		} else {
-			writePP = true
-		}
-
+	inputFile := flag.Arg(0)
+	flag.CommandLine.Parse(flag.Args()[1:])
+	if flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(1)
 	}
-	inputFile := args[0]
-
-	// Get output file
-	outputFile := "./out.svb"
-	if len(args) == 3 {
-		if args[1] == "-o" {
-			outputFile = args[2]
-		}
+	layout, _, err := layoutFlags()
+	if err != nil {
+		fmt.Println("error in memory layout:", err)
+		os.Exit(1)
 	}
 
 	// Read input file
@@ -46,18 +47,18 @@ func main() {
 	}
 
 	// Pre-process input
-	lines, err := preProcess(b, true)
+	lines, err := preProcess(b, inputFile, true)
 	if err != nil {
 		fmt.Println("error pre-processing:", err)
 		os.Exit(1)
 	}
 
 	// Write pre-processed input
-	if writePP {
+	if *writePP {
 		ppOut := ""
 		for _, i := range lines {
 			content := false
-			for _, j := range i {
+			for _, j := range i.tokens {
 				if j != "" {
 					ppOut += j + " "
 					content = true
@@ -68,7 +69,7 @@ func main() {
 			}
 		}
 
-		err = ioutil.WriteFile(outputFile+".asm", []byte(ppOut), 0644)
+		err = ioutil.WriteFile(*outputFile+".asm", []byte(ppOut), 0644)
 		if err != nil {
 			fmt.Println("error writing pre-processed asm:", err)
 			os.Exit(1)
@@ -76,9 +77,9 @@ func main() {
 	}
 
 	// Create CPU
-	m := mem.NewRAM(mem.AddressSpace{}, 80, 25)
+	m := mem.NewRAMLayout(mem.AddressSpace{}, layout)
 	v := vga.NewVGA(m)
-	c := cpu.NewCPU(m, v)
+	c := cpu.NewCPU(mem.NewBus(m), v)
 
 	// Parse input
 	binary, err := parse(c, lines)
@@ -88,9 +89,19 @@ func main() {
 	}
 
 	// Write binary
-	err = ioutil.WriteFile(outputFile, binary.Bytes(), 0644)
+	binary.Layout = m.Layout()
+	err = ioutil.WriteFile(*outputFile, binary.Bytes(), 0644)
 	if err != nil {
 		fmt.Println("error writing binary:", err)
 		os.Exit(1)
 	}
+
+	// Write symbol table
+	if *writeSymbols {
+		err = ioutil.WriteFile(*outputFile+".sym", binary.Symbols().Bytes(), 0644)
+		if err != nil {
+			fmt.Println("error writing symbol table:", err)
+			os.Exit(1)
+		}
+	}
 }
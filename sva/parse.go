@@ -37,7 +37,7 @@ func parseNum(s string) (uint16, error) {
 }
 
 // parse will parse a pre-processed input file into an SVB struct.
-func parse(c *cpu.CPU, lines [][]string) (svb.SVB, error) {
+func parse(c *cpu.CPU, lines []line) (svb.SVB, error) {
 
 	vars := make(map[string]uint16)
 	subs := make(map[string]uint16)
@@ -49,19 +49,20 @@ func parse(c *cpu.CPU, lines [][]string) (svb.SVB, error) {
 	binary := svb.SVB{}
 
 	// Iterate lines
-	for _, splitLine := range lines {
+	for _, l := range lines {
+		splitLine := l.tokens
 
 		// Handle constants
 		if (len(splitLine) == 3) && (splitLine[1] == "=") {
 			if currentSub.Name != "" {
 				return svb.SVB{},
-					fmt.Errorf("you cannot define a constant inside of a subroutine (\"%s\" is in \"%s\")",
+					l.errorf("you cannot define a constant inside of a subroutine (\"%s\" is in \"%s\")",
 						splitLine,
 						currentSub.Name,
 					)
 			}
 			if _, exists := vars[splitLine[0]]; exists {
-				return svb.SVB{}, fmt.Errorf("constant \"%s\" defined more than once", splitLine[0])
+				return svb.SVB{}, l.errorf("constant \"%s\" defined more than once", splitLine[0])
 			}
 			if len(splitLine[2]) > 2 {
 
@@ -89,7 +90,7 @@ func parse(c *cpu.CPU, lines [][]string) (svb.SVB, error) {
 					// Handle a hex value
 					val, err := util.ParseHex(splitLine[2][2:])
 					if err != nil {
-						return svb.SVB{}, err
+						return svb.SVB{}, l.errorf("%s", err)
 					}
 					// Create constant
 					vars[splitLine[0]] = address
@@ -106,7 +107,7 @@ func parse(c *cpu.CPU, lines [][]string) (svb.SVB, error) {
 			// Handle an int
 			i, err := parseNum(splitLine[2])
 			if err != nil {
-				return svb.SVB{}, err
+				return svb.SVB{}, l.errorf("%s", err)
 			}
 			vars[splitLine[0]] = address
 			constants = append(constants, svb.Constant{
@@ -120,19 +121,23 @@ func parse(c *cpu.CPU, lines [][]string) (svb.SVB, error) {
 			// Handle label definition
 			name := splitLine[0][1:]
 			if _, exists := labelAddresses[name]; exists {
-				return svb.SVB{}, fmt.Errorf("label \"%s\" defined more than once", name)
+				return svb.SVB{}, l.errorf("label \"%s\" defined more than once", name)
 			}
 			if currentSub.Name == "" {
-				return svb.SVB{}, fmt.Errorf("label \"%s\" defined outside of a subroutine", name)
+				return svb.SVB{}, l.errorf("label \"%s\" defined outside of a subroutine", name)
 			}
 
 			labelAddresses[name] = address + uint16(currentSub.Size())
+			binary.Labels = append(binary.Labels, svb.Label{
+				Name:    name,
+				Address: labelAddresses[name],
+			})
 
 		} else if len(splitLine) == 1 && len(splitLine[0]) > 1 && splitLine[0][len(splitLine[0])-1] == ':' {
 			// Handle subroutine definition
 			name := splitLine[0][:len(splitLine[0])-1]
 			if _, exists := subs[name]; exists {
-				return svb.SVB{}, fmt.Errorf("subroutine \"%s\" defined more than once", name)
+				return svb.SVB{}, l.errorf("subroutine \"%s\" defined more than once", name)
 			}
 			if currentSub.Name != "" {
 				binary.Subroutines = append(binary.Subroutines, currentSub)
@@ -150,16 +155,22 @@ func parse(c *cpu.CPU, lines [][]string) (svb.SVB, error) {
 			// Handle instruction
 			code, exists := dat.OpNameToCode[splitLine[0]]
 			if !exists {
-				return svb.SVB{}, fmt.Errorf("instruction \"%s\" does not exist", splitLine[0])
+				return svb.SVB{}, l.errorf("instruction \"%s\" does not exist", splitLine[0])
 			}
 			operands := make([]uint16, len(splitLine)-1)
 
+			// References in relative instructions are offsets from the next instruction
+			var next uint16
+			if dat.OpNameToRelative[splitLine[0]] {
+				next = address + uint16(currentSub.Size()+1+dat.OpNameToSize[splitLine[0]])
+			}
+
 			for i, j := range splitLine[1:] {
 				if (len(j) > 2) && (j[1] == 'x') {
 					// Handle hex number
 					val, err := util.ParseHex(j[2:])
 					if err != nil {
-						return svb.SVB{}, err
+						return svb.SVB{}, l.errorf("%s", err)
 					}
 					operands[i] = val
 
@@ -171,13 +182,14 @@ func parse(c *cpu.CPU, lines [][]string) (svb.SVB, error) {
 						len(currentSub.Instructions),
 						i,
 					})
-					operands[i] = 0
+					// The label address is added once it is known
+					operands[i] = -next
 
 				} else if (len(j) > 2) && (j[0] == '[') && (j[len(j)-1] == ']') {
 					// Handle constant reference
 					variable, exists := vars[j[1:len(j)-1]]
 					if !exists {
-						return svb.SVB{}, fmt.Errorf("constant \"%s\" not declared", j[1:len(j)-1])
+						return svb.SVB{}, l.errorf("constant \"%s\" not declared", j[1:len(j)-1])
 					}
 					operands[i] = variable
 
@@ -185,9 +197,9 @@ func parse(c *cpu.CPU, lines [][]string) (svb.SVB, error) {
 					// Handle subroutine reference
 					subAddr, exists := subs[j[1:len(j)-1]]
 					if !exists {
-						return svb.SVB{}, fmt.Errorf("subroutine \"%s\" not declared", j[1:len(j)-1])
+						return svb.SVB{}, l.errorf("subroutine \"%s\" not declared", j[1:len(j)-1])
 					}
-					operands[i] = subAddr
+					operands[i] = subAddr - next
 
 				} else if num, exists := dat.RegNamesToNum[j]; exists {
 					// Handle register alias
@@ -197,7 +209,7 @@ func parse(c *cpu.CPU, lines [][]string) (svb.SVB, error) {
 					// Handle an int
 					num, err := parseNum(j)
 					if err != nil {
-						return svb.SVB{}, err
+						return svb.SVB{}, l.errorf("%s", err)
 					}
 					operands[i] = num
 				}
@@ -207,7 +219,7 @@ func parse(c *cpu.CPU, lines [][]string) (svb.SVB, error) {
 			size := dat.OpNameToSize[splitLine[0]]
 			if len(operands) != size+dat.OpNameToPacked[splitLine[0]] {
 				return svb.SVB{},
-					fmt.Errorf("operation \"%s\" expected %d operands, but received %d",
+					l.errorf("operation \"%s\" expected %d operands, but received %d",
 						splitLine,
 						size,
 						len(operands),
@@ -216,13 +228,14 @@ func parse(c *cpu.CPU, lines [][]string) (svb.SVB, error) {
 
 			// Check to make sure instruction is in a defined subroutine
 			if currentSub.Name == "" {
-				return svb.SVB{}, fmt.Errorf("instruction \"%s\" used outside of a subroutine", splitLine)
+				return svb.SVB{}, l.errorf("instruction \"%s\" used outside of a subroutine", splitLine)
 			}
 
 			currentSub.Instructions = append(currentSub.Instructions, svb.Instruction{
 				Name:     splitLine[0],
 				Opcode:   code,
 				Operands: operands,
+				Source:   l.source,
 			})
 		}
 	}
@@ -239,7 +252,7 @@ func parse(c *cpu.CPU, lines [][]string) (svb.SVB, error) {
 	// Set label addresses
 	for k, v := range labelIndices {
 		for _, ref := range v {
-			binary.Subroutines[ref[0]].Instructions[ref[1]].Operands[ref[2]] = labelAddresses[k]
+			binary.Subroutines[ref[0]].Instructions[ref[1]].Operands[ref[2]] += labelAddresses[k]
 		}
 	}
 
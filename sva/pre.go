@@ -2,12 +2,24 @@ package main
 
 import (
 	"fmt"
+	"github.com/tteeoo/svc/svb"
 	"io/ioutil"
 	"os"
 	"path"
 	"strings"
 )
 
+// line is a tokenized line of assembly, and where it was defined.
+type line struct {
+	tokens []string
+	source svb.Source
+}
+
+// errorf returns an error prefixed with the source location of a line.
+func (l line) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("%s: %s", l.source, fmt.Sprintf(format, a...))
+}
+
 // Handle ex register expansion "ldr (0) aa" -> "cpl ex 0", "ldr ex aa"
 func registerExpansion(splitLine []string, idx int) ([][]string, error) {
 	first := []string{"cpl", "ex"}
@@ -85,18 +97,20 @@ func detectExpansion(splitLine []string) ([][]string, error) {
 	return [][]string{splitLine}, nil
 }
 
-// preProcess will preProcess an assembly file.
-// It will remove comments and expand file sources.
-func preProcess(b []byte, allowSource bool) ([][]string, error) {
+// preProcess will preProcess an assembly file, read from file.
+// It will remove comments and expand file sources, keeping the source
+//   location of each line.
+func preProcess(b []byte, file string, allowSource bool) ([]line, error) {
 
-	var lines [][]string
+	var lines []line
 	split := strings.Split(string(b), "\n")
 
-	for _, line := range split {
+	for n, text := range split {
+		source := svb.Source{File: file, Line: n + 1}
 
 		// Parse out comments
 		noComments := ""
-		for _, char := range line {
+		for _, char := range text {
 			if char == ';' {
 				break
 			}
@@ -124,26 +138,27 @@ func preProcess(b []byte, allowSource bool) ([][]string, error) {
 		}
 
 		// Detect expansions
+		l := line{tokens: splitLine, source: source}
 		expandedLines, err := detectExpansion(splitLine)
 		if err != nil {
-			return [][]string{}, err
+			return []line{}, l.errorf("%s", err)
 		}
 		if len(expandedLines) != 1 {
-			for _, l := range expandedLines {
-				lines = append(lines, l)
+			for _, tokens := range expandedLines {
+				lines = append(lines, line{tokens: tokens, source: source})
 			}
 			continue
 		}
 
 		// Normal instruction
 		if len(splitLine) != 2 || splitLine[0] != "." {
-			lines = append(lines, splitLine)
+			lines = append(lines, l)
 			continue
 		}
 
 		// Handle file sourcing
 		if !allowSource {
-			return [][]string{}, fmt.Errorf("cannot recursively source files (attempting to source %s)", splitLine[1])
+			return []line{}, l.errorf("cannot recursively source files (attempting to source %s)", splitLine[1])
 		}
 		var fb []byte
 
@@ -151,24 +166,24 @@ func preProcess(b []byte, allowSource bool) ([][]string, error) {
 		if path.IsAbs(splitLine[1]) {
 			fb, err = ioutil.ReadFile(splitLine[1])
 			if err != nil {
-				return [][]string{}, err
+				return []line{}, l.errorf("%s", err)
 			}
 		} else {
 			// Handle relative path
 			wd, err := os.Getwd()
 			if err != nil {
-				return [][]string{}, err
+				return []line{}, err
 			}
 			fb, err = ioutil.ReadFile(path.Join(wd, splitLine[1]))
 			if err != nil {
-				return [][]string{}, err
+				return []line{}, l.errorf("%s", err)
 			}
 		}
 
 		// Append lines from file
-		flines, err := preProcess(fb, false)
+		flines, err := preProcess(fb, splitLine[1], false)
 		if err != nil {
-			return [][]string{}, err
+			return []line{}, err
 		}
 		lines = append(lines, flines...)
 	}
@@ -1,85 +1,164 @@
 package svb
 
 import (
-	"bytes"
-	"encoding/binary"
+	"fmt"
 	"github.com/tteeoo/svc/cpu"
 	"github.com/tteeoo/svc/dat"
 	"github.com/tteeoo/svc/mem"
 	"github.com/tteeoo/svc/util"
 )
 
-// LoadProgram takes the bytes of an SVB file and parses out
-//   the new address space, main subroutine address, and program size.
-func LoadProgram(c *cpu.CPU, b []byte) (mem.AddressSpace, uint16, uint16) {
-
-	// []byte -> []uint16
+// toWords converts the bytes of an SVB file to words.
+func toWords(b []byte) []uint16 {
 	u := make([]uint16, len(b)/2)
 	for i := 0; i < cap(u); i++ {
 		u[i] = util.BytesToUint([]byte{b[i*2], b[(i*2)+1]})
 	}
+	return u
+}
 
-	// Extract headers (extensible)
-	mainAddress := uint16(0)
-	headerIndex := 0
+// header returns the header words of a legacy SVB file, and the index of
+//   the 0xffff word that terminates them, or false if there is none.
+func header(u []uint16) ([]uint16, int, bool) {
 	for i := 0; i < len(u); i++ {
 		if u[i] == 0xffff {
-			headerIndex = i
-			break
-		}
-		switch i {
-		case 0:
-			mainAddress = u[i]
+			return u[:i], i, true
 		}
 	}
+	return []uint16{}, 0, false
+}
 
-	// []uint16 -> address space
-	as := make(mem.AddressSpace)
-	for i, j := range u[headerIndex+1:] {
-		as[c.Mem.ProgramOffset+uint16(i)] = j
+// ReadLayout takes the bytes of an SVB file and parses out the memory layout
+//   it was assembled for, returning false if it was not recorded.
+func ReadLayout(b []byte) (mem.Layout, bool) {
+	f, err := Parse(b)
+	if err != nil {
+		return mem.Layout{}, false
 	}
+	return f.Layout()
+}
 
-	return as, mainAddress, uint16(len(u[headerIndex+1:]))
+// LoadProgram takes the bytes of an SVB file and parses out
+//   the new address space, main subroutine address, and program size.
+func LoadProgram(c *cpu.CPU, b []byte) (mem.AddressSpace, uint16, uint16, error) {
+	f, err := Parse(b)
+	if err != nil {
+		return mem.AddressSpace{}, 0, 0, err
+	}
+	return f.Load(c)
+}
+
+// Load returns the address space holding the code and data sections,
+//   the main subroutine address, and the program size (the number of words
+//   from the program offset to the end of the last section).
+// The sections must fit between the program offset and the system words,
+//   and the main address must be in a code section.
+func (f *File) Load(c *cpu.CPU) (mem.AddressSpace, uint16, uint16, error) {
+	as := mem.AddressSpace{}
+	end := uint32(c.Mem.ProgramOffset)
+	mainInCode := false
+	for _, s := range f.Sections {
+		if s.Kind != SectionCode && s.Kind != SectionData {
+			continue
+		}
+		address := f.Address(s, c.Mem.ProgramOffset)
+		u := s.Words()
+		e := uint32(address) + uint32(len(u))
+
+		// Check that the section fits
+		if e > 0x10000 {
+			return mem.AddressSpace{}, 0, 0,
+				fmt.Errorf("the %s section at %x (%d words) overflows the address space", s.Kind, address, len(u))
+		}
+		if address < c.Mem.ProgramOffset || e > uint32(c.Mem.SystemOffset) {
+			return mem.AddressSpace{}, 0, 0,
+				fmt.Errorf("the %s section at %x (%d words) does not fit in the program space (%x-%x)",
+					s.Kind, address, len(u), c.Mem.ProgramOffset, c.Mem.SystemOffset-1)
+		}
+		if s.Kind == SectionCode && uint32(f.MainAddress) >= uint32(address) && uint32(f.MainAddress) < e {
+			mainInCode = true
+		}
+
+		for i, j := range u {
+			as[address+uint16(i)] = j
+		}
+		if e > end {
+			end = e
+		}
+	}
+	if !mainInCode {
+		return mem.AddressSpace{}, 0, 0, fmt.Errorf("the main address %x is outside of the program's code", f.MainAddress)
+	}
+
+	return as, f.MainAddress, uint16(end - uint32(c.Mem.ProgramOffset)), nil
+}
+
+// Address returns the address a section is loaded at, given the address
+//   programs are loaded at.
+func (f *File) Address(s Section, programOffset uint16) uint16 {
+	if f.Legacy {
+		return programOffset
+	}
+	return s.Address
 }
 
 // Bytes serializes an SVB.
 func (s SVB) Bytes() []byte {
+	f := &File{MainAddress: s.MainAddress}
 
 	// Add constants
-	u := make([]uint16, s.Size()+2)
-	u[0] = s.MainAddress
-	u[1] = 0xffff
-	for i, c := range s.Constants {
-		u[i+2] = c.Value
+	if len(s.Constants) > 0 {
+		u := make([]uint16, len(s.Constants))
+		for i, c := range s.Constants {
+			u[i] = c.Value
+		}
+		f.Sections = append(f.Sections, Section{
+			Kind:    SectionData,
+			Address: s.Constants[0].Address,
+			Data:    fromWords(u),
+		})
 	}
 
 	// Add subroutines
-	i := uint16(len(s.Constants)) + 2
-	for _, sub := range s.Subroutines {
-		for _, op := range sub.Instructions {
-
-			// Pack operands
-			code := (op.Opcode << 8)
-			packed := dat.OpNameToPacked[op.Name]
-			switch packed {
-			case 0:
-				u[i] = code
-			case 1:
-				u[i] = code | op.Operands[0]
-			case 2:
-				u[i] = code | (op.Operands[0] << 4) | op.Operands[1]
-			}
+	if len(s.Subroutines) > 0 {
+		u := []uint16{}
+		for _, sub := range s.Subroutines {
+			for _, op := range sub.Instructions {
 
-			i++
-			for _, and := range op.Operands[packed:] {
-				u[i] = and
-				i++
+				// Pack operands
+				code := (op.Opcode << 8)
+				packed := dat.OpNameToPacked[op.Name]
+				switch packed {
+				case 0:
+					u = append(u, code)
+				case 1:
+					u = append(u, code|op.Operands[0])
+				case 2:
+					u = append(u, code|(op.Operands[0]<<4)|op.Operands[1])
+				}
+				u = append(u, op.Operands[packed:]...)
 			}
 		}
+		f.Sections = append(f.Sections, Section{
+			Kind:    SectionCode,
+			Address: s.Subroutines[0].Address,
+			Data:    fromWords(u),
+		})
 	}
 
-	// Convert uint16 to binary
-	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.BigEndian, u)
-	return buf.Bytes()
+	// Add symbols, lines, and layout
+	f.Sections = append(f.Sections,
+		Section{Kind: SectionSymbols, Data: s.Symbols().Bytes()},
+		Section{Kind: SectionDebug, Data: s.Lines().Bytes()},
+		Section{Kind: SectionLayout, Data: fromWords([]uint16{
+			s.Layout.VGAOffset,
+			uint16(s.Layout.VGAWidth),
+			uint16(s.Layout.VGAHeight),
+			s.Layout.StackSize,
+			s.Layout.ProgramOffset,
+			s.Layout.SystemOffset,
+		})},
+	)
+
+	return f.Bytes()
 }
@@ -0,0 +1,362 @@
+package svb
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/tteeoo/svc/dat"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Decode decodes the instruction at the start of u. It returns false if the
+//   opcode does not exist, bits no operand uses are set, or extra operands
+//   are missing.
+func Decode(u []uint16) (Instruction, bool) {
+	if len(u) == 0 {
+		return Instruction{}, false
+	}
+	name, exists := dat.OpCodeToName[u[0]>>8]
+	if !exists {
+		return Instruction{}, false
+	}
+	size := dat.OpNameToSize[name]
+	if len(u) < 1+size {
+		return Instruction{}, false
+	}
+	in := Instruction{Name: name, Opcode: u[0] >> 8, Operands: []uint16{}}
+
+	// Unpack operands
+	switch dat.OpNameToPacked[name] {
+	case 0:
+		if u[0]&0xff != 0 {
+			return Instruction{}, false
+		}
+	case 1:
+		if u[0]&0xf0 != 0 {
+			return Instruction{}, false
+		}
+		in.Operands = append(in.Operands, u[0]&0xf)
+	case 2:
+		in.Operands = append(in.Operands, (u[0]>>4)&0xf, u[0]&0xf)
+	}
+	in.Operands = append(in.Operands, u[1:1+size]...)
+	return in, true
+}
+
+// Disassembler writes the code and data sections of an SVB file
+//   as assembly.
+type Disassembler struct {
+	// Symbols, if set, name subroutines, labels, and constants, and the
+	//   addresses operands refer to.
+	// Without them, the code is a subroutine from its start, and main.
+	Symbols Symbols
+	// Lines, if set, are used to annotate instructions in listings.
+	Lines Lines
+	// Source writes assembly that sva assembles into the same program,
+	//   instead of a listing with addresses and words.
+	Source bool
+	// ProgramOffset is the address programs that do not record their memory
+	//   layout are loaded at.
+	ProgramOffset uint16
+}
+
+// region is a code or data section, and where it is loaded.
+type region struct {
+	Section
+	address uint16
+}
+
+// Disassemble writes the disassembly of f to w.
+// In source mode, it returns an error if the program cannot be written
+//   as source, for example if its code holds words that are not instructions.
+func (d *Disassembler) Disassemble(w io.Writer, f *File) error {
+	bw := bufio.NewWriter(w)
+	layout, ok := f.Layout()
+	programOffset := d.ProgramOffset
+	if ok {
+		programOffset = layout.Resolved().ProgramOffset
+	}
+
+	// Find the sections to disassemble, in order
+	regions := []region{}
+	for _, s := range f.Sections {
+		if s.Kind == SectionCode || s.Kind == SectionData {
+			regions = append(regions, region{Section: s, address: f.Address(s, programOffset)})
+		}
+	}
+	sort.SliceStable(regions, func(i, j int) bool { return regions[i].address < regions[j].address })
+
+	// Describe how to assemble the source
+	if d.Source {
+		if ok {
+			fmt.Fprintf(bw, "; assemble with: sva <file> -vga %dx%d -stack %d -program %x -system %x\n",
+				layout.VGAWidth, layout.VGAHeight, layout.StackSize, programOffset, layout.SystemOffset)
+		} else {
+			fmt.Fprintf(bw, "; assemble with: sva <file> -program %x\n", programOffset)
+		}
+	}
+
+	next := programOffset
+	inCode := false
+	for _, r := range regions {
+		u := r.Words()
+		if d.Source {
+			// sva places constants, then subroutines, one after another
+			if r.address != next {
+				return fmt.Errorf("the %s section at %x does not follow the previous one, so it cannot be written as source", r.Kind, r.address)
+			}
+			if r.Kind == SectionData && inCode {
+				return fmt.Errorf("the data section at %x follows code, so it cannot be written as source", r.address)
+			}
+		} else if len(u) > 0 {
+			fmt.Fprintf(bw, "; %s section at %04x-%04x\n", r.Kind, r.address, r.address+uint16(len(u)-1))
+		}
+		next = r.address + uint16(len(u))
+
+		var err error
+		if r.Kind == SectionData {
+			d.data(bw, r.address, u)
+		} else {
+			inCode = true
+			err = d.code(bw, f, r.address, u)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// data writes the definitions of the constants in u, loaded at address.
+func (d *Disassembler) data(w *bufio.Writer, address uint16, u []uint16) {
+	for i := 0; i < len(u); {
+		a := address + uint16(i)
+		name := fmt.Sprintf("data_%04x", a)
+		n := 1
+		for _, sym := range d.Symbols.At(a) {
+			if sym.Kind == SymbolConstant && sym.Size > 0 {
+				name = sym.Name
+				n = int(sym.Size)
+				break
+			}
+		}
+		if n > len(u)-i {
+			n = len(u) - i
+		}
+
+		// Write strings as strings, else each word on its own
+		if str, ok := stringConstant(u[i : i+n]); ok {
+			d.constant(w, a, u[i:i+n], name, str)
+			i += n
+			continue
+		}
+		for j := 0; j < n; j++ {
+			jname := name
+			if j > 0 {
+				jname = fmt.Sprintf("%s_%d", name, j)
+			}
+			d.constant(w, a+uint16(j), u[i+j:i+j+1], jname, fmt.Sprintf("0x%x", u[i+j]))
+		}
+		i += n
+	}
+}
+
+// constant writes the definition of a constant.
+func (d *Disassembler) constant(w *bufio.Writer, address uint16, u []uint16, name, value string) {
+	if d.Source {
+		fmt.Fprintf(w, "%s = %s\n", name, value)
+		return
+	}
+	ws := words(u[:1])
+	if len(u) > 1 {
+		ws += " .."
+	}
+	fmt.Fprintf(w, "%04x  %-10s  %s = %s\n", address, ws, name, value)
+}
+
+// stringConstant returns the words of a constant as a string that sva
+//   assembles into the same words, or false if there is none.
+func stringConstant(u []uint16) (string, bool) {
+	if len(u) < 2 || u[len(u)-1] != 0 {
+		return "", false
+	}
+	var b strings.Builder
+	for _, c := range u[:len(u)-1] {
+		// Comments start with ';', and runs of spaces and tabs are lost
+		if c < ' ' || c > '~' || c == ';' {
+			return "", false
+		}
+		b.WriteRune(rune(c))
+	}
+	if strings.Contains(b.String(), "  ") {
+		return "", false
+	}
+	return "\"" + b.String() + "\"", true
+}
+
+// code writes the subroutines, labels, and instructions in u, loaded at address.
+func (d *Disassembler) code(w *bufio.Writer, f *File, address uint16, u []uint16) error {
+	sub := address
+	inSub := false
+	for i := 0; i < len(u); {
+		a := address + uint16(i)
+		in, ok := Decode(u[i:])
+
+		// Words before the first subroutine are constants to sva
+		if !ok && !inSub {
+			d.data(w, a, u[i:i+1])
+			i++
+			continue
+		}
+
+		// Write subroutine and label definitions
+		named := false
+		for _, sym := range d.Symbols.At(a) {
+			switch sym.Kind {
+			case SymbolSubroutine:
+				d.subroutine(w, sym.Name)
+				sub, inSub, named = a, true, true
+			case SymbolLabel:
+				if !inSub {
+					d.subroutine(w, fmt.Sprintf("sub_%04x", a))
+					sub, inSub = a, true
+				}
+				d.header(w, "  &"+sym.Name)
+			}
+		}
+		if !named && (!inSub || (len(d.Symbols) == 0 && a == f.MainAddress)) {
+			name := fmt.Sprintf("sub_%04x", a)
+			if a == f.MainAddress {
+				name = "main"
+			}
+			d.subroutine(w, name)
+			sub, inSub = a, true
+		}
+
+		// Write the instruction
+		if !ok {
+			if d.Source {
+				return fmt.Errorf("the word at %x is not an instruction, so it cannot be written as source", a)
+			}
+			fmt.Fprintf(w, "%04x  %-10s  ; not an instruction\n", a, words(u[i:i+1]))
+			i++
+			continue
+		}
+		size := in.Size()
+		text, comments := d.instruction(in, a, sub)
+		if d.Source {
+			fmt.Fprintf(w, "  %s\n", text)
+		} else {
+			if src, ok := d.Lines.Lookup(a); ok {
+				comments = append(comments, src.String())
+			}
+			line := fmt.Sprintf("%04x  %-10s    %-24s", a, words(u[i:i+size]), text)
+			if len(comments) > 0 {
+				line += "; " + strings.Join(comments, ", ")
+			}
+			fmt.Fprintln(w, strings.TrimRight(line, " "))
+		}
+		i += size
+	}
+
+	// Write labels after the last instruction
+	for _, sym := range d.Symbols.At(address + uint16(len(u))) {
+		if sym.Kind == SymbolLabel && inSub {
+			d.header(w, "  &"+sym.Name)
+		}
+	}
+	return nil
+}
+
+// subroutine writes a subroutine definition, separated from what precedes
+//   it in source.
+func (d *Disassembler) subroutine(w *bufio.Writer, name string) {
+	if d.Source {
+		fmt.Fprintln(w)
+	}
+	d.header(w, name+":")
+}
+
+// header writes a subroutine or label definition.
+func (d *Disassembler) header(w *bufio.Writer, text string) {
+	if d.Source {
+		fmt.Fprintln(w, text)
+		return
+	}
+	fmt.Fprintf(w, "%18s%s\n", "", text)
+}
+
+// instruction formats an instruction at pc, in the subroutine at sub,
+//   with comments for a listing.
+func (d *Disassembler) instruction(in Instruction, pc, sub uint16) (string, []string) {
+	packed := dat.OpNameToPacked[in.Name]
+	relative := dat.OpNameToRelative[in.Name]
+	// Jump instructions are named starting with g, for go to
+	jump := strings.HasPrefix(in.Name, "g")
+	next := pc + uint16(in.Size())
+	ops := []string{in.Name}
+	comments := []string{}
+	for i, o := range in.Operands {
+		// Packed operands are registers
+		if i < packed {
+			if o < dat.RegNum {
+				ops = append(ops, dat.RegNumToName[o])
+			} else {
+				ops = append(ops, fmt.Sprintf("0x%x", o))
+			}
+			continue
+		}
+
+		target := o
+		if relative {
+			target = next + o
+		}
+		if ref, ok := d.reference(target, relative, jump, sub); ok {
+			ops = append(ops, ref)
+			continue
+		}
+		ops = append(ops, fmt.Sprintf("0x%x", o))
+		if relative {
+			comments = append(comments, fmt.Sprintf("to %04x", target))
+		}
+	}
+	return strings.Join(ops, " "), comments
+}
+
+// reference returns a reference to the symbol starting at an address,
+//   as an operand of an instruction in the subroutine at sub.
+// A jump to the start of sub is within it, so a label there is preferred.
+func (d *Disassembler) reference(address uint16, relative, jump bool, sub uint16) (string, bool) {
+	symbols := d.Symbols.At(address)
+	if jump && address == sub {
+		for _, sym := range symbols {
+			if sym.Kind == SymbolLabel {
+				return "&" + sym.Name, true
+			}
+		}
+	}
+	for _, sym := range symbols {
+		switch {
+		// sva only knows the subroutines defined so far
+		case sym.Kind == SymbolSubroutine && (!d.Source || sym.Address <= sub):
+			return "{" + sym.Name + "}", true
+		case sym.Kind == SymbolLabel:
+			return "&" + sym.Name, true
+		// References to constants are addresses, not offsets
+		case sym.Kind == SymbolConstant && !relative:
+			return "[" + sym.Name + "]", true
+		}
+	}
+	return "", false
+}
+
+// words formats words in hex, separated by spaces.
+func words(u []uint16) string {
+	s := make([]string, len(u))
+	for i, w := range u {
+		s[i] = fmt.Sprintf("%04x", w)
+	}
+	return strings.Join(s, " ")
+}
@@ -0,0 +1,111 @@
+package svb
+
+import (
+	"github.com/tteeoo/svc/cpu"
+	"github.com/tteeoo/svc/dat"
+	"github.com/tteeoo/svc/mem"
+	"testing"
+)
+
+// fetched records the instructions the CPU decodes.
+type fetched struct {
+	cpu.NopObserver
+	in cpu.Instruction
+}
+
+// BeforeExecute implements cpu.Observer.
+func (f *fetched) BeforeExecute(c *cpu.CPU, in cpu.Instruction) {
+	f.in = in
+}
+
+// TestDecodeMatchesCPU checks that Decode decodes every opcode the same way
+//   the CPU does, and rejects words with bits no operand uses set.
+func TestDecodeMatchesCPU(t *testing.T) {
+	for code, info := range dat.Ops {
+		if info.Name == "" {
+			continue
+		}
+		for _, low := range []uint16{0x00, 0x01, 0x05, 0x0d, 0x10, 0x39, 0xf0, 0xff} {
+			word := uint16(code)<<8 | low
+			u := []uint16{word, 0x0905}
+
+			// Decode it
+			in, ok := Decode(u)
+			unused := uint16(0xff)
+			switch info.Packed {
+			case 1:
+				unused = 0xf0
+			case 2:
+				unused = 0
+			}
+			if want := low&unused == 0; ok != want {
+				t.Errorf("Decode(%04x) returned %t, want %t", word, ok, want)
+				continue
+			}
+			if !ok {
+				continue
+			}
+
+			// Run it
+			m := mem.NewRAMLayout(mem.AddressSpace{}, mem.DefaultLayout())
+			c := cpu.NewCPU(mem.NewBus(m), nil)
+			pc := m.ProgramOffset
+			m.Mem[pc], m.Mem[pc+1] = u[0], u[1]
+			c.Regs[dat.PC] = pc
+			f := &fetched{}
+			c.Observe(f)
+			c.Step()
+
+			if in.Name != f.in.Info.Name {
+				t.Errorf("Decode(%04x) returned %s, the CPU ran %s", word, in.Name, f.in.Info.Name)
+				continue
+			}
+			args := f.in.Args()
+			if len(in.Operands) != len(args) {
+				t.Errorf("Decode(%04x) returned operands %x, the CPU used %x", word, in.Operands, args)
+				continue
+			}
+			for i := range args {
+				if in.Operands[i] != args[i] {
+					t.Errorf("Decode(%04x) returned operands %x, the CPU used %x", word, in.Operands, args)
+					break
+				}
+			}
+		}
+	}
+}
+
+// TestDecodeTruncated checks that Decode rejects missing extra operands.
+func TestDecodeTruncated(t *testing.T) {
+	if _, ok := Decode([]uint16{dat.OpNameToCode["cal"] << 8}); ok {
+		t.Error("Decode accepted cal without its address")
+	}
+	if _, ok := Decode(nil); ok {
+		t.Error("Decode accepted no words")
+	}
+}
+
+// TestReferenceLabelAtSubroutine checks that jumps to a label at the start
+//   of the current subroutine refer to the label, and calls to the subroutine.
+func TestReferenceLabelAtSubroutine(t *testing.T) {
+	d := &Disassembler{Symbols: Symbols{
+		{Name: "print", Kind: SymbolSubroutine, Address: 0x900, Size: 0x10},
+		{Name: "loop_print_str", Kind: SymbolLabel, Address: 0x900},
+		{Name: "main", Kind: SymbolSubroutine, Address: 0x910, Size: 0x10},
+	}}
+	tests := []struct {
+		in       Instruction
+		pc, sub  uint16
+		expected string
+	}{
+		{Instruction{Name: "gto", Operands: []uint16{0x900}}, 0x908, 0x900, "gto &loop_print_str"},
+		{Instruction{Name: "cal", Operands: []uint16{0x900}}, 0x908, 0x900, "cal {print}"},
+		{Instruction{Name: "gto", Operands: []uint16{0x900}}, 0x918, 0x910, "gto {print}"},
+		{Instruction{Name: "cal", Operands: []uint16{0x900}}, 0x918, 0x910, "cal {print}"},
+	}
+	for _, test := range tests {
+		if got, _ := d.instruction(test.in, test.pc, test.sub); got != test.expected {
+			t.Errorf("%s at %x in %x: got %q, want %q", test.in.Name, test.pc, test.sub, got, test.expected)
+		}
+	}
+}
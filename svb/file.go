@@ -0,0 +1,257 @@
+package svb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/tteeoo/svc/mem"
+	"hash/crc32"
+)
+
+// Magic starts every SVB file, except legacy files.
+const Magic = "\xffSVB"
+
+// Version is the version of the format written by File.Bytes.
+const Version uint16 = 1
+
+// SectionKind is the kind of a Section.
+type SectionKind uint16
+
+// Section kinds.
+const (
+	// SectionCode holds instruction words, loaded at the section's address.
+	SectionCode SectionKind = iota + 1
+	// SectionData holds constant words, loaded at the section's address.
+	SectionData
+	// SectionSymbols holds the symbol table.
+	SectionSymbols
+	// SectionDebug holds the line table.
+	SectionDebug
+	// SectionLayout holds the memory layout the program was assembled for.
+	SectionLayout
+)
+
+// String returns the name of a section kind.
+func (k SectionKind) String() string {
+	switch k {
+	case SectionCode:
+		return "code"
+	case SectionData:
+		return "data"
+	case SectionSymbols:
+		return "symbols"
+	case SectionDebug:
+		return "debug"
+	case SectionLayout:
+		return "layout"
+	}
+	return fmt.Sprintf("unknown (%d)", uint16(k))
+}
+
+// Section is a section of an SVB file.
+type Section struct {
+	Kind SectionKind
+	// Address is where a code or data section is loaded, else 0.
+	Address uint16
+	Data    []byte
+}
+
+// Words returns the data of a section as big-endian words.
+func (s Section) Words() []uint16 {
+	return toWords(s.Data)
+}
+
+// File is a parsed SVB file.
+//
+// An SVB file starts with a header:
+//   * Magic (4 bytes).
+//   * The version (a word).
+//   * The main address (a word).
+//   * The number of sections (a word).
+//   * A CRC-32 (IEEE) checksum of the file, computed with the checksum
+//     set to 0 (2 words).
+// The section table follows, with an entry for each section holding its kind
+//   and address (words), and its offset from the start of the file and size
+//   in bytes (2 words each). Words are big-endian.
+//
+// Legacy files hold the main address, optionally the memory layout, and a
+//   0xffff word, followed by the words of the program.
+type File struct {
+	Version     uint16
+	MainAddress uint16
+	Sections    []Section
+	// Legacy is true if the file was in the legacy format. Legacy files have
+	//   a single code section, which is loaded at the program offset.
+	Legacy bool
+}
+
+// headerSize is the size in bytes of the header, and entrySize of
+//   a section table entry.
+const (
+	headerSize = 14
+	entrySize  = 12
+)
+
+// Parse parses an SVB file, in the current or the legacy format.
+func Parse(b []byte) (*File, error) {
+	if len(b) == 0 {
+		return nil, errors.New("empty file")
+	}
+	if len(b)%2 != 0 {
+		return nil, errors.New("truncated file, it has an odd number of bytes")
+	}
+	if !bytes.HasPrefix(b, []byte(Magic)) {
+		return parseLegacy(b)
+	}
+	if len(b) < headerSize {
+		return nil, errors.New("truncated header")
+	}
+	f := &File{
+		Version:     binary.BigEndian.Uint16(b[4:]),
+		MainAddress: binary.BigEndian.Uint16(b[6:]),
+	}
+	if f.Version != Version {
+		return nil, fmt.Errorf("unsupported version %d", f.Version)
+	}
+	count := int(binary.BigEndian.Uint16(b[8:]))
+	sum := binary.BigEndian.Uint32(b[10:])
+	if sum != checksum(b) {
+		return nil, errors.New("checksum mismatch, the file is corrupt")
+	}
+	if len(b) < headerSize+count*entrySize {
+		return nil, errors.New("truncated section table")
+	}
+	for i := 0; i < count; i++ {
+		e := b[headerSize+i*entrySize:]
+		offset := uint64(binary.BigEndian.Uint32(e[4:]))
+		size := uint64(binary.BigEndian.Uint32(e[8:]))
+		if offset+size > uint64(len(b)) {
+			return nil, fmt.Errorf("section %d is out of bounds", i)
+		}
+		s := Section{
+			Kind:    SectionKind(binary.BigEndian.Uint16(e)),
+			Address: binary.BigEndian.Uint16(e[2:]),
+			Data:    b[offset : offset+size],
+		}
+		if (s.Kind == SectionCode || s.Kind == SectionData) && size%2 != 0 {
+			return nil, fmt.Errorf("%s section %d is truncated, it has an odd number of bytes", s.Kind, i)
+		}
+		f.Sections = append(f.Sections, s)
+	}
+	return f, nil
+}
+
+// parseLegacy parses an SVB file in the legacy format.
+func parseLegacy(b []byte) (*File, error) {
+	u := toWords(b)
+	f := &File{Legacy: true}
+	h, headerIndex, ok := header(u)
+	if !ok {
+		return nil, errors.New("truncated header, no 0xffff word ends it")
+	}
+	if len(h) == 0 {
+		return nil, errors.New("the header is missing the main address")
+	}
+	f.MainAddress = h[0]
+	if len(h) >= 7 {
+		f.Sections = append(f.Sections, Section{Kind: SectionLayout, Data: fromWords(h[1:7])})
+	}
+	f.Sections = append(f.Sections, Section{Kind: SectionCode, Data: fromWords(u[headerIndex+1:])})
+	return f, nil
+}
+
+// Bytes serializes a File in the current format.
+func (f *File) Bytes() []byte {
+	size := headerSize + len(f.Sections)*entrySize
+	for _, s := range f.Sections {
+		size += len(s.Data) + len(s.Data)%2
+	}
+	b := make([]byte, headerSize+len(f.Sections)*entrySize, size)
+	copy(b, Magic)
+	binary.BigEndian.PutUint16(b[4:], Version)
+	binary.BigEndian.PutUint16(b[6:], f.MainAddress)
+	binary.BigEndian.PutUint16(b[8:], uint16(len(f.Sections)))
+	for i, s := range f.Sections {
+		e := b[headerSize+i*entrySize:]
+		binary.BigEndian.PutUint16(e, uint16(s.Kind))
+		binary.BigEndian.PutUint16(e[2:], s.Address)
+		binary.BigEndian.PutUint32(e[4:], uint32(len(b)))
+		binary.BigEndian.PutUint32(e[8:], uint32(len(s.Data)))
+		b = append(b, s.Data...)
+
+		// Keep sections aligned to words
+		if len(s.Data)%2 != 0 {
+			b = append(b, 0)
+		}
+	}
+	binary.BigEndian.PutUint32(b[10:], checksum(b))
+	return b
+}
+
+// Section returns the first section of a kind.
+func (f *File) Section(k SectionKind) (Section, bool) {
+	for _, s := range f.Sections {
+		if s.Kind == k {
+			return s, true
+		}
+	}
+	return Section{}, false
+}
+
+// Layout returns the memory layout the program was assembled for,
+//   or false if it was not recorded.
+func (f *File) Layout() (mem.Layout, bool) {
+	s, ok := f.Section(SectionLayout)
+	if !ok {
+		return mem.Layout{}, false
+	}
+	h := s.Words()
+	if len(h) < 6 {
+		return mem.Layout{}, false
+	}
+	return mem.Layout{
+		VGAOffset:     h[0],
+		VGAWidth:      int(h[1]),
+		VGAHeight:     int(h[2]),
+		StackSize:     h[3],
+		ProgramOffset: h[4],
+		SystemOffset:  h[5],
+	}, true
+}
+
+// Symbols returns the symbol table, or nil if there is none.
+func (f *File) Symbols() (Symbols, error) {
+	s, ok := f.Section(SectionSymbols)
+	if !ok {
+		return nil, nil
+	}
+	return ParseSymbols(s.Data)
+}
+
+// Lines returns the line table, or nil if there is none.
+func (f *File) Lines() (Lines, error) {
+	s, ok := f.Section(SectionDebug)
+	if !ok {
+		return nil, nil
+	}
+	return ParseLines(s.Data)
+}
+
+// checksum returns the CRC-32 of an SVB file, skipping its checksum.
+func checksum(b []byte) uint32 {
+	h := crc32.NewIEEE()
+	h.Write(b[:10])
+	h.Write([]byte{0, 0, 0, 0})
+	h.Write(b[headerSize:])
+	return h.Sum32()
+}
+
+// fromWords converts words to big-endian bytes.
+func fromWords(u []uint16) []byte {
+	b := make([]byte, len(u)*2)
+	for i, w := range u {
+		binary.BigEndian.PutUint16(b[i*2:], w)
+	}
+	return b
+}
@@ -0,0 +1,85 @@
+package svb
+
+import (
+	"github.com/tteeoo/svc/cpu"
+	"github.com/tteeoo/svc/mem"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// load parses and loads an SVB file, with the layout it records
+//   or the default one.
+func load(b []byte) error {
+	f, err := Parse(b)
+	if err != nil {
+		return err
+	}
+	layout, ok := f.Layout()
+	if !ok || layout.Validate() != nil {
+		layout = mem.DefaultLayout()
+	}
+	c := cpu.NewCPU(mem.NewBus(mem.NewRAMLayout(mem.AddressSpace{}, layout)), nil)
+	_, _, _, err = f.Load(c)
+	return err
+}
+
+// TestLoad checks that each file in testdata loads, or fails with
+//   the expected error.
+func TestLoad(t *testing.T) {
+	want := map[string]string{
+		"valid.svb":    "",
+		"legacy.svb":   "",
+		"odd.svb":      "odd number of bytes",
+		"noterm.svb":   "no 0xffff word",
+		"header.svb":   "truncated header",
+		"table.svb":    "truncated section table",
+		"checksum.svb": "checksum mismatch",
+		"bounds.svb":   "out of bounds",
+		"main.svb":     "main address 100 is outside",
+		"overflow.svb": "overflows the address space",
+	}
+	names, err := filepath.Glob(filepath.Join("testdata", "*.svb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != len(want) {
+		t.Errorf("found %d files in testdata, want %d", len(names), len(want))
+	}
+	for _, name := range names {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, exists := want[filepath.Base(name)]
+		if !exists {
+			t.Errorf("%s: no expected result", name)
+			continue
+		}
+		err = load(b)
+		switch {
+		case expected == "" && err != nil:
+			t.Errorf("%s: unexpected error: %s", name, err)
+		case expected != "" && err == nil:
+			t.Errorf("%s: loaded, want an error containing \"%s\"", name, expected)
+		case expected != "" && !strings.Contains(err.Error(), expected):
+			t.Errorf("%s: error \"%s\", want one containing \"%s\"", name, err, expected)
+		}
+	}
+}
+
+// TestLoadTruncations checks that no prefix of the files in testdata
+//   makes Parse or Load panic.
+func TestLoadTruncations(t *testing.T) {
+	names, _ := filepath.Glob(filepath.Join("testdata", "*.svb"))
+	for _, name := range names {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range b {
+			load(b[:i])
+		}
+	}
+}
@@ -0,0 +1,27 @@
+// +build gofuzz
+
+package svb
+
+import (
+	"github.com/tteeoo/svc/cpu"
+	"github.com/tteeoo/svc/mem"
+)
+
+// Fuzz is the entry point for go-fuzz (github.com/dvyukov/go-fuzz),
+//   seeded with the files in testdata.
+// It parses and loads data, which must not panic.
+func Fuzz(data []byte) int {
+	f, err := Parse(data)
+	if err != nil {
+		return 0
+	}
+	layout, ok := f.Layout()
+	if !ok || layout.Validate() != nil {
+		layout = mem.DefaultLayout()
+	}
+	c := cpu.NewCPU(mem.NewBus(mem.NewRAMLayout(mem.AddressSpace{}, layout)), nil)
+	if _, _, _, err := f.Load(c); err != nil {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,97 @@
+package svb
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Source is the location of a line of assembly.
+type Source struct {
+	File string
+	// Line is the line number, starting at 1.
+	Line int
+}
+
+// String formats a source location like "io.asm:12".
+func (s Source) String() string {
+	return fmt.Sprintf("%s:%d", s.File, s.Line)
+}
+
+// Line maps the address of an instruction to the line of assembly
+//   it was assembled from.
+type Line struct {
+	Address uint16
+	Source  Source
+}
+
+// Lines is a line table, sorted by address.
+type Lines []Line
+
+// Lines returns the line table of the instructions of an SVB
+//   that have a source location.
+func (s SVB) Lines() Lines {
+	t := Lines{}
+	for _, sub := range s.Subroutines {
+		address := sub.Address
+		for _, in := range sub.Instructions {
+			if in.Source.File != "" {
+				t = append(t, Line{Address: address, Source: in.Source})
+			}
+			address += uint16(in.Size())
+		}
+	}
+	sort.SliceStable(t, func(i, j int) bool { return t[i].Address < t[j].Address })
+	return t
+}
+
+// Lookup returns the source location of the instruction at an address.
+func (t Lines) Lookup(address uint16) (Source, bool) {
+	i := sort.Search(len(t), func(i int) bool { return t[i].Address >= address })
+	if i == len(t) || t[i].Address != address {
+		return Source{}, false
+	}
+	return t[i].Source, true
+}
+
+// Bytes serializes a line table as text, with a line holding the address
+//   (in hex), the line number, and the file of each instruction.
+func (t Lines) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	for _, l := range t {
+		fmt.Fprintf(buf, "%x %d %s\n", l.Address, l.Source.Line, l.Source.File)
+	}
+	return buf.Bytes()
+}
+
+// ParseLines parses a line table serialized by Lines.Bytes.
+func ParseLines(b []byte) (Lines, error) {
+	t := Lines{}
+	for i, line := range strings.Split(string(b), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		// The file is last, as it may hold spaces
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 || fields[2] == "" {
+			return nil, fmt.Errorf("line %d: expected an address, a line number, and a file", i+1)
+		}
+		address, err := strconv.ParseUint(fields[0], 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid address \"%s\"", i+1, fields[0])
+		}
+		num, err := strconv.Atoi(fields[1])
+		if err != nil || num < 1 {
+			return nil, fmt.Errorf("line %d: invalid line number \"%s\"", i+1, fields[1])
+		}
+		t = append(t, Line{
+			Address: uint16(address),
+			Source:  Source{File: fields[2], Line: num},
+		})
+	}
+	sort.SliceStable(t, func(i, j int) bool { return t[i].Address < t[j].Address })
+	return t, nil
+}
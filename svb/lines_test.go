@@ -0,0 +1,29 @@
+package svb
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestLinesRoundTrip checks that a line table parses back from its
+//   serialized form unchanged, including files with spaces in their names.
+func TestLinesRoundTrip(t *testing.T) {
+	lines := Lines{
+		{Address: 0x900, Source: Source{File: "lib/io.asm", Line: 20}},
+		{Address: 0x901, Source: Source{File: "lib/io.asm", Line: 21}},
+		{Address: 0x91c, Source: Source{File: "hello world.asm", Line: 7}},
+	}
+	parsed, err := ParseLines(lines.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, lines) {
+		t.Errorf("parsed %v, want %v", parsed, lines)
+	}
+
+	for _, b := range []string{"900 20\n", "900 0 io.asm\n", "x 1 io.asm\n"} {
+		if _, err := ParseLines([]byte(b)); err == nil {
+			t.Errorf("ParseLines(%q) returned no error", b)
+		}
+	}
+}
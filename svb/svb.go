@@ -4,6 +4,7 @@ package svb
 
 import (
 	"github.com/tteeoo/svc/dat"
+	"github.com/tteeoo/svc/mem"
 )
 
 // Constant represents a constant defined in assembly.
@@ -18,6 +19,8 @@ type Instruction struct {
 	Name     string
 	Opcode   uint16
 	Operands []uint16
+	// Source is where the instruction was defined, if known.
+	Source Source
 }
 
 // Size calculates the size of an Instruction.
@@ -41,11 +44,19 @@ func (s Subroutine) Size() int {
 	return size
 }
 
+// Label represents a label defined in a subroutine in assembly.
+type Label struct {
+	Name    string
+	Address uint16
+}
+
 // SVB represents a Simple Virtual Binary formatted file.
 type SVB struct {
 	Constants   []Constant
 	Subroutines []Subroutine
+	Labels      []Label
 	MainAddress uint16
+	Layout      mem.Layout
 }
 
 // Size calculates size of an SVB.
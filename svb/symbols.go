@@ -0,0 +1,220 @@
+package svb
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SymbolKind is the kind of a Symbol.
+type SymbolKind uint8
+
+// Symbol kinds.
+const (
+	SymbolSubroutine SymbolKind = iota
+	SymbolConstant
+	// SymbolLabel is a label in a subroutine, covering the words up to
+	//   the next label or the end of the subroutine.
+	SymbolLabel
+)
+
+// symbolKindNames maps symbol kinds to their names in serialized symbol tables.
+var symbolKindNames = map[SymbolKind]string{
+	SymbolSubroutine: "subroutine",
+	SymbolConstant:   "constant",
+	SymbolLabel:      "label",
+}
+
+// String returns the name of a symbol kind.
+func (k SymbolKind) String() string {
+	if name, exists := symbolKindNames[k]; exists {
+		return name
+	}
+	return fmt.Sprintf("unknown (%d)", uint8(k))
+}
+
+// Symbol is a named range of memory in a program.
+type Symbol struct {
+	Name    string
+	Kind    SymbolKind
+	Address uint16
+	// Size is the number of words the symbol covers.
+	Size uint16
+}
+
+// Symbols is a symbol table, sorted by address, with subroutines
+//   and constants before the labels at the same address.
+type Symbols []Symbol
+
+// Symbols returns the symbol table of the subroutines, labels,
+//   and constants of an SVB.
+func (s SVB) Symbols() Symbols {
+	t := Symbols{}
+	for _, sub := range s.Subroutines {
+		t = append(t, Symbol{
+			Name:    sub.Name,
+			Kind:    SymbolSubroutine,
+			Address: sub.Address,
+			Size:    uint16(sub.Size()),
+		})
+	}
+
+	// Strings are stored as a constant for each character, with the same name
+	for _, c := range s.Constants {
+		if n := len(t) - 1; n >= 0 && t[n].Kind == SymbolConstant && t[n].Name == c.Name &&
+			t[n].Address+t[n].Size == c.Address {
+			t[n].Size++
+			continue
+		}
+		t = append(t, Symbol{
+			Name:    c.Name,
+			Kind:    SymbolConstant,
+			Address: c.Address,
+			Size:    1,
+		})
+	}
+
+	// Labels end at the next label or at the end of their subroutine
+	t.sort()
+	labels := append([]Label{}, s.Labels...)
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Address < labels[j].Address })
+	for i, l := range labels {
+		sub, ok := t.Lookup(l.Address)
+		end := uint32(l.Address)
+		if ok {
+			end = uint32(sub.Address) + uint32(sub.Size)
+		}
+		if i+1 < len(labels) && uint32(labels[i+1].Address) < end {
+			end = uint32(labels[i+1].Address)
+		}
+		t = append(t, Symbol{
+			Name:    l.Name,
+			Kind:    SymbolLabel,
+			Address: l.Address,
+			Size:    uint16(end - uint32(l.Address)),
+		})
+	}
+
+	t.sort()
+	return t
+}
+
+// sort sorts a symbol table by address, then by kind.
+func (t Symbols) sort() {
+	sort.SliceStable(t, func(i, j int) bool {
+		if t[i].Address != t[j].Address {
+			return t[i].Address < t[j].Address
+		}
+		return t[i].Kind < t[j].Kind
+	})
+}
+
+// Lookup returns the subroutine or constant covering an address.
+func (t Symbols) Lookup(address uint16) (Symbol, bool) {
+	i := sort.Search(len(t), func(i int) bool { return t[i].Address > address }) - 1
+	for ; i >= 0; i-- {
+		if t[i].Kind == SymbolLabel {
+			continue
+		}
+		if uint32(address) >= uint32(t[i].Address)+uint32(t[i].Size) {
+			break
+		}
+		return t[i], true
+	}
+	return Symbol{}, false
+}
+
+// At returns the symbols starting at an address.
+func (t Symbols) At(address uint16) Symbols {
+	i := sort.Search(len(t), func(i int) bool { return t[i].Address >= address })
+	j := i
+	for j < len(t) && t[j].Address == address {
+		j++
+	}
+	return t[i:j]
+}
+
+// Find returns the symbol of a kind with a name.
+func (t Symbols) Find(kind SymbolKind, name string) (Symbol, bool) {
+	for _, s := range t {
+		if s.Kind == kind && s.Name == name {
+			return s, true
+		}
+	}
+	return Symbol{}, false
+}
+
+// Resolve returns the address of the symbol with a name, trying
+//   subroutines, then labels, then constants.
+func (t Symbols) Resolve(name string) (uint16, bool) {
+	for _, kind := range []SymbolKind{SymbolSubroutine, SymbolLabel, SymbolConstant} {
+		if s, ok := t.Find(kind, name); ok {
+			return s.Address, true
+		}
+	}
+	return 0, false
+}
+
+// Describe returns an address relative to the subroutine or constant
+//   covering it, like "main+3", or "" if no symbol covers it.
+func (t Symbols) Describe(address uint16) string {
+	s, ok := t.Lookup(address)
+	if !ok {
+		return ""
+	}
+	if address == s.Address {
+		return s.Name
+	}
+	return fmt.Sprintf("%s+%x", s.Name, address-s.Address)
+}
+
+// Bytes serializes a symbol table as text, with a line holding the address
+//   and size (in hex), the kind, and the name of each symbol.
+func (t Symbols) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	for _, s := range t {
+		fmt.Fprintf(buf, "%x %x %s %s\n", s.Address, s.Size, s.Kind, s.Name)
+	}
+	return buf.Bytes()
+}
+
+// ParseSymbols parses a symbol table serialized by Symbols.Bytes.
+func ParseSymbols(b []byte) (Symbols, error) {
+	kinds := make(map[string]SymbolKind)
+	for k, name := range symbolKindNames {
+		kinds[name] = k
+	}
+
+	t := Symbols{}
+	for i, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("line %d: expected an address, a size, a kind, and a name", i+1)
+		}
+		address, err := strconv.ParseUint(fields[0], 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid address \"%s\"", i+1, fields[0])
+		}
+		size, err := strconv.ParseUint(fields[1], 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid size \"%s\"", i+1, fields[1])
+		}
+		kind, exists := kinds[fields[2]]
+		if !exists {
+			return nil, fmt.Errorf("line %d: invalid kind \"%s\"", i+1, fields[2])
+		}
+		t = append(t, Symbol{
+			Name:    fields[3],
+			Kind:    kind,
+			Address: uint16(address),
+			Size:    uint16(size),
+		})
+	}
+	t.sort()
+	return t, nil
+}
@@ -0,0 +1,31 @@
+package svb
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestSymbolsRoundTrip checks that a symbol table of each kind of symbol
+//   parses back from its serialized form unchanged.
+func TestSymbolsRoundTrip(t *testing.T) {
+	symbols := Symbols{
+		{Name: "hello", Kind: SymbolConstant, Address: 0x900, Size: 6},
+		{Name: "print", Kind: SymbolSubroutine, Address: 0x906, Size: 0x16},
+		{Name: "loop_print_str", Kind: SymbolLabel, Address: 0x906, Size: 0xd},
+		{Name: "after_print_str", Kind: SymbolLabel, Address: 0x913, Size: 9},
+		{Name: "main", Kind: SymbolSubroutine, Address: 0x91c, Size: 0},
+	}
+	parsed, err := ParseSymbols(symbols.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, symbols) {
+		t.Errorf("parsed %v, want %v", parsed, symbols)
+	}
+
+	for _, b := range []string{"900 6 constant\n", "900 6 register r\n", "10000 0 label l\n"} {
+		if _, err := ParseSymbols([]byte(b)); err == nil {
+			t.Errorf("ParseSymbols(%q) returned no error", b)
+		}
+	}
+}
@@ -1,45 +1,114 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/tteeoo/svc/cpu"
+	"github.com/tteeoo/svc/machine"
 	"github.com/tteeoo/svc/mem"
-	"github.com/tteeoo/svc/svb"
-	"github.com/tteeoo/svc/vga"
-	"io/ioutil"
+	"github.com/tteeoo/svc/snapshot"
+	"github.com/tteeoo/svc/util"
 	"os"
 )
 
 func main() {
 
-	// Get program file
-	if len(os.Args) < 2 {
-		fmt.Printf("run like this: %s <svb file>\n", os.Args[0])
+	// Parse flags
+	var env util.StringList
+	flag.Var(&env, "e", "set an environment entry (key=value), can be repeated")
+	layoutFlags := util.LayoutFlags()
+	restoreFile := flag.String("restore", "", "start from a snapshot file instead of a program")
+	replayFile := flag.String("replay", "", "replay a recording made with svc -record instead of running a program")
+	symbolsFile := flag.String("symbols", "", "read symbols from a symbol file written by sva -sym, instead of the program")
+	flag.Usage = func() {
+		fmt.Printf("run like this: %s [options] <svb file> [args]...\n", os.Args[0])
+		fmt.Printf("           or: %s [options] -restore <snapshot file>\n", os.Args[0])
+		fmt.Printf("           or: %s [options] -replay <tape file>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// Check arguments
+	sources := 0
+	for _, set := range []bool{*restoreFile != "", *replayFile != "", flag.NArg() > 0} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		flag.Usage()
 		os.Exit(1)
 	}
-	programFile := os.Args[1]
 
-	// Open program
-	b, err := ioutil.ReadFile(programFile)
+	// Read the program, snapshot, or recording
+	layout, layoutSet, err := layoutFlags()
 	if err != nil {
-		fmt.Println("error reading program file:", err)
+		fmt.Println("error in memory layout:", err)
+		os.Exit(1)
+	}
+	src, err := machine.Open(flag.Arg(0), *restoreFile, *replayFile, layout, layoutSet)
+	if err != nil {
+		fmt.Println("error:", err)
 		os.Exit(1)
 	}
 
-	m := mem.NewRAM(mem.AddressSpace{}, 80, 25)
-	v := vga.NewVGA(m)
-	c := cpu.NewCPU(m, v)
+	m := mem.NewRAMLayout(mem.AddressSpace{}, src.Layout)
+	// The debugger reports vga instructions instead of drawing text
+	c := cpu.NewCPU(mem.NewBus(m), nil)
 
 	// Load program
 	fmt.Println("simple virtual debugger version alpha")
-	fmt.Printf("loading file: [%s]\n", os.Args[1])
-	mainAddress := uint16(0)
-	programSize := uint16(0)
-	m.Mem, mainAddress, programSize = svb.LoadProgram(c, b)
+	if src.Snapshot != nil {
+		fmt.Printf("loading snapshot: [%s]\n", *restoreFile)
+	} else if src.Tape != nil {
+		fmt.Printf("loading recording: [%s]\n", *replayFile)
+	} else {
+		fmt.Printf("loading file: [%s]\n", flag.Arg(0))
+	}
+	mainAddress, err := src.Load(c)
+	if err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+
+	// Read symbols, from the symbol file or the program
+	symbols, err = src.Symbols(*symbolsFile)
+	if err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+
+	// Read source lines
+	if src.Program != nil {
+		lines, err = src.Program.Lines()
+		if err != nil {
+			fmt.Println("error reading program file:", err)
+			os.Exit(1)
+		}
+	}
 
-	// Calculate heap offset
-	m.HeapOffset += programSize
+	// Attach the recorded devices and replay their inputs,
+	//   or else attach a timer
+	s := cpu.Startup{Env: env}
+	if src.Tape != nil {
+		s = cpu.Startup{Args: src.Tape.Args, Env: src.Tape.Env}
+		replaying = true
+		if _, devices, err = machine.Replay(c, src.Tape); err != nil {
+			fmt.Println("error attaching devices:", err)
+			os.Exit(1)
+		}
+	} else {
+		t, err := machine.AttachTimer(c)
+		if err != nil {
+			fmt.Println("error attaching timer:", err)
+			os.Exit(1)
+		}
+		devices = map[string]snapshot.Device{"timer": t}
+		if src.Snapshot == nil {
+			s.Args = flag.Args()[1:]
+		}
+	}
 
 	// Start repl
-	repl(c, mainAddress)
+	repl(c, mainAddress, s, src.Snapshot)
 }
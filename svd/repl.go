@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"github.com/tteeoo/svc/cpu"
 	"github.com/tteeoo/svc/dat"
+	"github.com/tteeoo/svc/replay"
+	"github.com/tteeoo/svc/snapshot"
+	"github.com/tteeoo/svc/svb"
 	"github.com/tteeoo/svc/util"
 	"os"
 	"strconv"
@@ -14,8 +17,39 @@ import (
 
 var done bool
 
+// symbols is the symbol table of the program, if it has one.
+var symbols svb.Symbols
+
+// lines is the line table of the program, if it has one.
+var lines svb.Lines
+
+// replaying is set when a recording is stepped through. Snapshots do not
+//   hold the devices the recording attaches or the position in it, so they
+//   cannot be saved or loaded.
+var replaying bool
+
+// devices are the devices attached to the machine, by the names snapshots
+//   hold them under.
+var devices map[string]snapshot.Device
+
+// location formats an address, followed by the symbol covering it, if any.
+func location(address uint16) string {
+	if name := symbols.Describe(address); name != "" {
+		return fmt.Sprintf("%x <%s>", address, name)
+	}
+	return fmt.Sprintf("%x", address)
+}
+
+// parseAddress parses a hex address, or the name of a symbol.
+func parseAddress(s string) (uint16, error) {
+	if address, ok := symbols.Resolve(s); ok {
+		return address, nil
+	}
+	return util.ParseHex(s)
+}
+
 func run(c *cpu.CPU) bool {
-	pc := c.Regs[dat.RegNamesToNum["pc"]]
+	pc := c.Regs[dat.PC]
 
 	// Exit if pc is the last address
 	if pc == 0xffff {
@@ -24,68 +58,114 @@ func run(c *cpu.CPU) bool {
 		return true
 	}
 
-	// Get instruction
-	op := c.Mem.Get(pc)
-	name := dat.OpCodeToName[op>>8]
-	size := dat.OpNameToSize[name]
-	operands := make([]uint16, size)
-	for i := 0; i < size; i++ {
-		operands[i] = c.Mem.Get(pc + uint16(1+i))
+	// Deliver recorded inputs
+	if c.Poll != nil {
+		if err := c.Poll(c); err == replay.ErrEnd {
+			fmt.Println("end of recording, execution stopped")
+			done = true
+			return true
+		} else if err != nil {
+			fmt.Println(util.Color(err.Error(), "31;1"))
+			fmt.Println("execution stopped")
+			done = true
+			return true
+		}
 	}
-	fmt.Println(
-		util.Color(fmt.Sprintf("%x:", pc), "32;1"),
-		util.Color(fmt.Sprintf("%s(%x)", name, op), "31;1"),
-		util.Color(fmt.Sprintf("%x", operands), "31;1"),
-	)
 
-	// Increase program counter
-	c.Regs[dat.RegNamesToNum["pc"]] += uint16(1 + size)
+	// Stop if waiting for an interrupt that can never come
+	if c.IRQ.Waiting() && c.IRQ.Pending() == 0 {
+		fmt.Println("waiting for interrupt, execution stopped")
+		done = true
+		return true
+	}
 
 	// Execute instruction
-	if (op >> 8) == dat.OpNameToCode["vga"] {
-		fmt.Println(util.Color("text drawn", "35;1"))
-	} else {
-		c.Op(op, operands)
+	in, err := c.Step()
+	if in.Info != nil && in.PC != pc {
+		fmt.Println(util.Color(fmt.Sprintf("interrupt serviced, jumped to %s", location(in.PC)), "33;1"))
+	}
+	if in.Info != nil {
+		fmt.Println(
+			util.Color(location(in.PC)+":", "32;1"),
+			util.Color(fmt.Sprintf("%s(%x)", in.Info.Name, in.Word), "31;1"),
+			util.Color(fmt.Sprintf("%x", in.Operands[in.Info.Packed:in.Info.Packed+in.Info.Size]), "31;1"),
+		)
+		if src, ok := lines.Lookup(in.PC); ok {
+			fmt.Println(util.Color(fmt.Sprintf("from %s", src), "37;1"))
+		}
+		if dat.OpNameToRelative[in.Info.Name] {
+			target := in.PC + uint16(1+in.Info.Size) + in.Operands[in.Info.Packed]
+			fmt.Println(util.Color(fmt.Sprintf("relative to %s", location(target)), "31;1"))
+		}
+		if in.Info.Name == "vga" {
+			fmt.Println(util.Color("text drawn", "35;1"))
+		}
+	}
+	if err != nil {
+		f, ok := err.(*cpu.Fault)
+		if ok && in.Info != nil && c.DispatchFault(f) {
+			fmt.Println(util.Color(fmt.Sprintf("%s, jumped to fault handler", f), "31;1"))
+		} else {
+			fmt.Println(util.Color(err.Error(), "31;1"))
+			fmt.Println("execution stopped")
+			done = true
+			return true
+		}
+	}
+	if code, halted := c.Halted(); halted {
+		fmt.Printf("program exited with code %x, execution stopped\n", code)
+		done = true
+		return true
 	}
 
 	return false
 }
 
-func repl(c *cpu.CPU, address uint16) {
-
-	// Put command-line args into heap
-	var l uint16
-	if len(os.Args) > 2 {
-		i := c.Mem.HeapOffset
-		for _, str := range os.Args[2:] {
-			for _, char := range str {
-				c.Mem.Set(i, uint16(char))
-				i++
-			}
-			c.Mem.Set(i, 0)
-			i++
+// flagString lists the flags set in a value of the fl register.
+func flagString(flags uint16) string {
+	var set []string
+	for i, name := range []string{"carry", "overflow", "sign", "zero"} {
+		if flags&(1<<i) != 0 {
+			set = append(set, name)
 		}
-		fmt.Println(util.Color(fmt.Sprintf("argument(s) loaded into heap: %s", os.Args[2:]), "33;1"))
-		l = uint16(i - c.Mem.HeapOffset)
-
-		// Load heap information
-		c.Mem.Set(0xfffe, l)
-		c.Mem.Set(0xfffd, uint16(len(os.Args)-2))
 	}
-	c.Mem.Set(0xffff, c.Mem.HeapOffset)
+	return "[" + strings.Join(set, " ") + "]"
+}
 
-	// Push exit address onto stack
-	sp := dat.RegNamesToNum["sp"]
-	c.Mem.Set(c.Regs[sp], 0xffff)
+// boot loads startup state into memory, reporting what was loaded.
+func boot(c *cpu.CPU, address uint16, s cpu.Startup) {
+	if err := c.Boot(address, s); err != nil {
+		fmt.Println("error booting:", err)
+		os.Exit(1)
+	}
+	if len(s.Args) > 0 {
+		fmt.Println(util.Color(fmt.Sprintf("argument(s) loaded into heap: %s", s.Args), "33;1"))
+	}
+	if len(s.Env) > 0 {
+		fmt.Println(util.Color(fmt.Sprintf("environment loaded into heap: %s", s.Env), "33;1"))
+	}
+	if len(s.Stack) > 0 {
+		fmt.Println(util.Color(fmt.Sprintf("pushed %x onto the stack", s.Stack), "36;1"))
+	}
 	fmt.Println(util.Color("pushed ffff onto the stack", "36;1"))
-
-	// Set the program counter
-	c.Regs[dat.RegNamesToNum["pc"]] = address
 	fmt.Println(util.Color(fmt.Sprintf("program counter set to %x", address), "32;1"))
+}
+
+func repl(c *cpu.CPU, address uint16, s cpu.Startup, snap *snapshot.Snapshot) {
+
+	// Load startup state into memory, or restore the snapshot
+	if snap != nil {
+		if err := snap.Restore(c, devices); err != nil {
+			fmt.Println("error restoring snapshot:", err)
+			os.Exit(1)
+		}
+		fmt.Println(util.Color(fmt.Sprintf("snapshot restored, program counter is %x", c.Regs[dat.PC]), "32;1"))
+	} else {
+		boot(c, address, s)
+	}
 	fmt.Println("run `h` for help")
 
 	// Enter the execution loop
-	var cycles int
 	rl, _ := readline.New("> ")
 	for {
 		// Read input
@@ -103,7 +183,6 @@ func repl(c *cpu.CPU, address uint16) {
 				continue
 			}
 			run(c)
-			cycles++
 		// CPU
 		case "c":
 			// Print registers
@@ -123,9 +202,13 @@ func repl(c *cpu.CPU, address uint16) {
 					} else {
 						ansic = "34;1"
 					}
+					value := fmt.Sprintf("%x", c.Regs[v])
+					if v == dat.FL {
+						value += " " + flagString(c.Regs[v])
+					}
 					fmt.Println(
 						util.Color(fmt.Sprintf("%s(%x):", k, v), ansic),
-						util.Color(fmt.Sprintf("%x", c.Regs[v]), ansic),
+						util.Color(value, ansic),
 					)
 				}
 			} else if len(command) == 3 {
@@ -151,13 +234,17 @@ func repl(c *cpu.CPU, address uint16) {
 			if len(command) == 1 {
 				fmt.Println(util.Color(fmt.Sprintf("%s: %x-%x", "text", 0, c.Mem.StackMin-1), "35;1"))
 				fmt.Println(util.Color(fmt.Sprintf("%s: %x-%x", "stak", c.Mem.StackMin, c.Mem.StackMax), "36;1"))
+				if c.Mem.ProgramOffset > c.Mem.StackMax+1 {
+					fmt.Println(util.Color(fmt.Sprintf("%s: %x-%x", "free", c.Mem.StackMax+1, c.Mem.ProgramOffset-1), "37;1"))
+				}
 				fmt.Println(util.Color(fmt.Sprintf("%s: %x-%x, main: %x", "prog", c.Mem.ProgramOffset, c.Mem.HeapOffset-1, address), "32;1"))
-				fmt.Println(util.Color(fmt.Sprintf("%s: %x-%x", "heap", c.Mem.HeapOffset, 0xffff), "33;1"))
+				fmt.Println(util.Color(fmt.Sprintf("%s: %x-%x", "heap", c.Mem.HeapOffset, c.Mem.SystemOffset-1), "33;1"))
+				fmt.Println(util.Color(fmt.Sprintf("%s: %x-%x", "syst", c.Mem.SystemOffset, 0xffff), "34;1"))
 			} else if len(command) == 2 {
 				// Print memory
 				memRange := strings.Split(command[1], "-")
 				if len(memRange) == 1 {
-					value, err := util.ParseHex(memRange[0])
+					value, err := parseAddress(memRange[0])
 					if err != nil {
 						fmt.Println("invalid address")
 						continue
@@ -170,12 +257,12 @@ func repl(c *cpu.CPU, address uint16) {
 					)
 				} else if len(memRange) == 2 {
 					// Print memory range
-					start, err := util.ParseHex(memRange[0])
+					start, err := parseAddress(memRange[0])
 					if err != nil {
 						fmt.Println("invalid range")
 						continue
 					}
-					end, err := util.ParseHex(memRange[1])
+					end, err := parseAddress(memRange[1])
 					if err != nil {
 						fmt.Println("invalid range")
 						continue
@@ -217,7 +304,7 @@ func repl(c *cpu.CPU, address uint16) {
 				}
 			} else if len(command) == 3 {
 				// Set memory
-				key, err := util.ParseHex(command[1])
+				key, err := parseAddress(command[1])
 				if err != nil {
 					fmt.Println("invalid address")
 					continue
@@ -238,7 +325,79 @@ func repl(c *cpu.CPU, address uint16) {
 				fmt.Println("invalid command")
 			}
 		case "n":
-			fmt.Println(util.Color(fmt.Sprintf("%d", cycles), "34;1"))
+			fmt.Println(util.Color(fmt.Sprintf("%d", c.Cycles), "34;1"))
+		// Symbols
+		case "sym":
+			if len(symbols) == 0 {
+				fmt.Println("the program has no symbols")
+				continue
+			}
+			if len(command) == 1 {
+				// Print symbol table
+				for _, sym := range symbols {
+					end := sym.Address
+					if sym.Size > 0 {
+						end += sym.Size - 1
+					}
+					fmt.Println(
+						util.Color(fmt.Sprintf("%x-%x:", sym.Address, end), "32;1"),
+						sym.Kind, sym.Name,
+					)
+				}
+			} else if len(command) == 2 {
+				// Look up a symbol
+				address, err := parseAddress(command[1])
+				if err != nil {
+					fmt.Println("invalid address")
+					continue
+				}
+				name := symbols.Describe(address)
+				if name == "" {
+					name = "no symbol"
+				}
+				fmt.Println(util.Color(fmt.Sprintf("%x:", address), "32;1"), name)
+				for _, sym := range symbols.At(address) {
+					if sym.Kind == svb.SymbolLabel {
+						fmt.Println(util.Color(fmt.Sprintf("%x:", address), "32;1"), "label", sym.Name)
+					}
+				}
+			} else {
+				fmt.Println("invalid command")
+			}
+		// Snapshots
+		case "s":
+			if replaying {
+				fmt.Println("snapshots cannot be used while replaying a recording")
+				continue
+			}
+			if len(command) != 2 {
+				fmt.Println("invalid command")
+				continue
+			}
+			if err := snapshot.WriteFile(command[1], snapshot.Take(c, devices)); err != nil {
+				fmt.Println("error saving snapshot:", err)
+				continue
+			}
+			fmt.Printf("saved snapshot to %s\n", command[1])
+		case "l":
+			if replaying {
+				fmt.Println("snapshots cannot be used while replaying a recording")
+				continue
+			}
+			if len(command) != 2 {
+				fmt.Println("invalid command")
+				continue
+			}
+			snap, err := snapshot.ReadFile(command[1])
+			if err == nil {
+				err = snap.Restore(c, devices)
+			}
+			if err != nil {
+				fmt.Println("error loading snapshot:", err)
+				continue
+			}
+			done = false
+			fmt.Println(util.Color(fmt.Sprintf("snapshot loaded, program counter is %x", c.Regs[dat.PC]), "32;1"))
 		case "h", "?", "help":
 			fmt.Println("h      print this help message")
 			fmt.Println("<num>  execute a number of instructions")
@@ -249,6 +408,11 @@ func repl(c *cpu.CPU, address uint16) {
 			fmt.Println("m <addr>          print memory address")
 			fmt.Println("m <addr>-<addr>   print range of memory")
 			fmt.Println("m <addr> <value>  set memory address")
+			fmt.Println("sym         print the symbol table")
+			fmt.Println("sym <addr>  print the symbol covering an address")
+			fmt.Println("s <file>  save a snapshot of the machine")
+			fmt.Println("l <file>  load a snapshot of the machine")
+			fmt.Println("addresses are in hex, or the name of a subroutine, label, or constant")
 			fmt.Println("press enter with no command to execute a single instruction")
 		default:
 			// Try number
@@ -260,7 +424,6 @@ func repl(c *cpu.CPU, address uint16) {
 						continue
 					}
 					for i := 0; i < num; i++ {
-						cycles++
 						if run(c) {
 							break
 						}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/tteeoo/svc/svb"
+	"github.com/tteeoo/svc/util"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+func main() {
+
+	// Parse flags, which may come before or after the input file
+	outputFile := flag.String("o", "", "output file (default stdout)")
+	source := flag.Bool("s", false, "write assembly that sva can assemble, instead of a listing")
+	symbolsFile := flag.String("symbols", "", "read symbols from a symbol file written by sva -sym, instead of the program")
+	layoutFlags := util.LayoutFlags()
+	flag.Usage = func() {
+		fmt.Printf("run like this: %s <svb file> [-o <output file>] [-s] [-symbols <file>] [layout options]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	inputFile := flag.Arg(0)
+	flag.CommandLine.Parse(flag.Args()[1:])
+	if flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	layout, _, err := layoutFlags()
+	if err != nil {
+		fmt.Println("error in memory layout:", err)
+		os.Exit(1)
+	}
+
+	// Read input file
+	b, err := ioutil.ReadFile(inputFile)
+	if err != nil {
+		fmt.Println("error reading program file:", err)
+		os.Exit(1)
+	}
+	prog, err := svb.Parse(b)
+	if err != nil {
+		fmt.Println("error reading program file:", err)
+		os.Exit(1)
+	}
+
+	// Read symbols and lines
+	d := &svb.Disassembler{
+		Source:        *source,
+		ProgramOffset: layout.Resolved().ProgramOffset,
+	}
+	if *symbolsFile != "" {
+		sb, err := ioutil.ReadFile(*symbolsFile)
+		if err == nil {
+			d.Symbols, err = svb.ParseSymbols(sb)
+		}
+		if err != nil {
+			fmt.Println("error reading symbol file:", err)
+			os.Exit(1)
+		}
+	} else {
+		d.Symbols, err = prog.Symbols()
+		if err != nil {
+			fmt.Println("error reading program file:", err)
+			os.Exit(1)
+		}
+	}
+	d.Lines, err = prog.Lines()
+	if err != nil {
+		fmt.Println("error reading program file:", err)
+		os.Exit(1)
+	}
+
+	// Write disassembly
+	w := io.Writer(os.Stdout)
+	if *outputFile != "" {
+		f, err := os.Create(*outputFile)
+		if err != nil {
+			fmt.Println("error creating output file:", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := d.Disassemble(w, prog); err != nil {
+		fmt.Println("error disassembling:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tteeoo/svc/disk"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+)
+
+func usage() {
+	fmt.Printf("run like this: %s <command> <image> [arguments]...\n", os.Args[0])
+	fmt.Println("commands:")
+	fmt.Println("  format <image> <sectors>        create an image with an empty filesystem")
+	fmt.Println("  ls <image>                      list files")
+	fmt.Println("  put <image> <host file> [name]  copy a host file into the image")
+	fmt.Println("  get <image> <name> [host file]  copy a file out of the image")
+	fmt.Println("  rm <image> <name>               remove a file")
+}
+
+// open opens the filesystem on an image file.
+func open(image string) (*disk.FS, *os.File, error) {
+	f, err := os.OpenFile(image, os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	d, err := disk.NewDrive(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	fs, err := disk.Open(d)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return fs, f, nil
+}
+
+func main() {
+
+	// Get command
+	if len(os.Args) < 3 {
+		usage()
+		os.Exit(1)
+	}
+	command, image, args := os.Args[1], os.Args[2], os.Args[3:]
+
+	if err := run(command, image, args); err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+}
+
+// run executes a command on an image.
+func run(command, image string, args []string) error {
+
+	// Create a new image
+	if command == "format" {
+		if len(args) != 1 {
+			usage()
+			os.Exit(1)
+		}
+		sectors, err := strconv.Atoi(args[0])
+		if err != nil || sectors < 1 || sectors > 0xffff {
+			return fmt.Errorf("invalid number of sectors \"%s\"", args[0])
+		}
+		f, err := os.Create(image)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		size := int64(sectors) * disk.SectorSize * 2
+		if err := f.Truncate(size); err != nil {
+			return err
+		}
+		d, err := disk.NewDrive(f, size)
+		if err != nil {
+			return err
+		}
+		_, err = disk.Format(d)
+		return err
+	}
+
+	fs, f, err := open(image)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	switch {
+	case command == "ls" && len(args) == 0:
+		entries, err := fs.List()
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			fmt.Printf("%-12s %5d words, sector %x\n", e.Name, e.Size, e.Start)
+		}
+
+	case command == "put" && (len(args) == 1 || len(args) == 2):
+		// Each byte of the host file is stored in one word
+		b, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		name := path.Base(args[0])
+		if len(args) == 2 {
+			name = args[1]
+		}
+		u := make([]uint16, len(b))
+		for i, c := range b {
+			u[i] = uint16(c)
+		}
+		return fs.WriteFile(name, u)
+
+	case command == "get" && (len(args) == 1 || len(args) == 2):
+		// The low byte of each word is written to the host file
+		u, err := fs.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		b := make([]byte, len(u))
+		for i, w := range u {
+			b[i] = byte(w)
+		}
+		if len(args) == 1 {
+			_, err = os.Stdout.Write(b)
+			return err
+		}
+		return ioutil.WriteFile(args[1], b, 0644)
+
+	case command == "rm" && len(args) == 1:
+		return fs.Remove(args[0])
+
+	default:
+		usage()
+		os.Exit(1)
+	}
+	return nil
+}
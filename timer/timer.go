@@ -0,0 +1,205 @@
+// Package timer implements a programmable interval timer device.
+package timer
+
+import (
+	"fmt"
+	"github.com/tteeoo/svc/cpu"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// IRQ is the interrupt line raised when the counter reaches zero.
+const IRQ = 0
+
+// Timer registers, as offsets from where the timer is mapped.
+const (
+	// ControlRegister holds the Control bits.
+	// Writing to it loads the counter from the reload register.
+	ControlRegister uint16 = 0
+	// ReloadRegister holds the value the counter starts from.
+	ReloadRegister uint16 = 1
+	// CounterRegister holds the number of ticks left until the counter reaches zero.
+	CounterRegister uint16 = 2
+	// StatusRegister is 1 when the counter has reached zero.
+	// Writing to it acknowledges this, setting it back to 0.
+	StatusRegister uint16 = 3
+	// Registers is the number of registers.
+	Registers = 4
+)
+
+// Bits of the control register.
+const (
+	// ControlEnable makes the counter count down.
+	ControlEnable uint16 = 1 << iota
+	// ControlPeriodic reloads the counter when it reaches zero,
+	//   instead of stopping it (one-shot mode).
+	ControlPeriodic
+	// ControlWallClock counts milliseconds instead of executed instructions.
+	ControlWallClock
+	// ControlInterrupt raises IRQ when the counter reaches zero.
+	ControlInterrupt
+)
+
+// Timer represents a programmable interval timer.
+// It counts down once per executed instruction, or once per millisecond
+//   with ControlWallClock, and must be added to the CPU as an observer.
+type Timer struct {
+	cpu.NopObserver
+	// OnTick, if set, is called instead of counting down each millisecond
+	//   with ControlWallClock, so ticks can be recorded and replayed with Tick.
+	OnTick  func()
+	irq     *cpu.Interrupts
+	mu      sync.Mutex
+	control uint16
+	reload  uint16
+	counter uint16
+	status  uint16
+	// cycles is 1 if the timer counts instructions.
+	// It is accessed atomically, so it can be checked without locking.
+	cycles uint32
+	stop   chan struct{}
+}
+
+// NewTimer returns a pointer to a newly initialized Timer
+//   raising interrupts on irq.
+func NewTimer(irq *cpu.Interrupts) *Timer {
+	return &Timer{
+		irq: irq,
+	}
+}
+
+// AfterExecute implements cpu.Observer, counting executed instructions.
+func (t *Timer) AfterExecute(c *cpu.CPU, in cpu.Instruction, err error) {
+	if atomic.LoadUint32(&t.cycles) == 1 {
+		t.Tick()
+	}
+}
+
+// Read implements mem.Device.
+func (t *Timer) Read(offset uint16) uint16 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch offset {
+	case ControlRegister:
+		return t.control
+	case ReloadRegister:
+		return t.reload
+	case CounterRegister:
+		return t.counter
+	case StatusRegister:
+		return t.status
+	}
+	return 0
+}
+
+// Write implements mem.Device.
+func (t *Timer) Write(offset uint16, value uint16) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch offset {
+	case ControlRegister:
+		t.control = value
+		t.counter = t.reload
+		t.update()
+	case ReloadRegister:
+		t.reload = value
+	case CounterRegister:
+		t.counter = value
+	case StatusRegister:
+		t.status = 0
+	}
+}
+
+// Stop stops counting milliseconds in the background, if the timer is.
+func (t *Timer) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.control &^= ControlEnable
+	t.update()
+}
+
+// SaveState returns the registers, for snapshots.
+func (t *Timer) SaveState() []uint16 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return []uint16{t.control, t.reload, t.counter, t.status}
+}
+
+// LoadState restores the registers from a snapshot, starting or stopping
+//   counting to match.
+func (t *Timer) LoadState(state []uint16) error {
+	if len(state) != Registers {
+		return fmt.Errorf("invalid timer state (%d words)", len(state))
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.control, t.reload, t.counter, t.status = state[0], state[1], state[2], state[3]
+	t.update()
+	return nil
+}
+
+// Tick counts down once.
+func (t *Timer) Tick() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.control&ControlEnable == 0 {
+		return
+	}
+	if t.counter > 0 {
+		t.counter--
+	}
+	if t.counter != 0 {
+		return
+	}
+
+	// The counter has reached zero
+	t.status = 1
+	if t.control&ControlInterrupt != 0 {
+		t.irq.Raise(IRQ)
+	}
+	if t.control&ControlPeriodic != 0 && t.reload != 0 {
+		t.counter = t.reload
+	} else {
+		t.control &^= ControlEnable
+		t.update()
+	}
+}
+
+// update starts or stops counting to match the control register.
+// t.mu must be held.
+func (t *Timer) update() {
+	enabled := t.control&ControlEnable != 0
+	wall := t.control&ControlWallClock != 0
+	if enabled && !wall {
+		atomic.StoreUint32(&t.cycles, 1)
+	} else {
+		atomic.StoreUint32(&t.cycles, 0)
+	}
+
+	if t.stop != nil && !(enabled && wall) {
+		close(t.stop)
+		t.stop = nil
+	} else if t.stop == nil && enabled && wall {
+		t.stop = make(chan struct{})
+		go t.count(t.stop)
+	}
+}
+
+// count ticks once per millisecond until stop is closed.
+func (t *Timer) count(stop chan struct{}) {
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if t.OnTick != nil {
+				t.OnTick()
+			} else {
+				t.Tick()
+			}
+		case <-stop:
+			return
+		}
+	}
+}
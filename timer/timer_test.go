@@ -0,0 +1,74 @@
+package timer_test
+
+import (
+	"github.com/tteeoo/svc/cpu"
+	"github.com/tteeoo/svc/timer"
+	"testing"
+)
+
+// execute has the timer observe n executed instructions.
+func execute(t *timer.Timer, n int) {
+	for i := 0; i < n; i++ {
+		t.AfterExecute(nil, cpu.Instruction{}, nil)
+	}
+}
+
+func TestOneShot(t *testing.T) {
+	irq := cpu.NewInterrupts()
+	tm := timer.NewTimer(irq)
+	tm.Write(timer.ReloadRegister, 3)
+	tm.Write(timer.ControlRegister, timer.ControlEnable|timer.ControlInterrupt)
+
+	execute(tm, 2)
+	if c := tm.Read(timer.CounterRegister); c != 1 || tm.Read(timer.StatusRegister) != 0 || irq.Pending() != 0 {
+		t.Fatalf("counter %d, status %d, pending %x after 2 instructions", c, tm.Read(timer.StatusRegister), irq.Pending())
+	}
+	execute(tm, 1)
+	if tm.Read(timer.StatusRegister) != 1 || irq.Pending() != 1<<timer.IRQ {
+		t.Fatalf("status %d, pending %x after reaching zero", tm.Read(timer.StatusRegister), irq.Pending())
+	}
+	if tm.Read(timer.ControlRegister)&timer.ControlEnable != 0 {
+		t.Errorf("the timer is still enabled after reaching zero")
+	}
+
+	// It stays stopped
+	execute(tm, 5)
+	if c := tm.Read(timer.CounterRegister); c != 0 {
+		t.Errorf("counter %d after stopping", c)
+	}
+}
+
+func TestPeriodic(t *testing.T) {
+	irq := cpu.NewInterrupts()
+	tm := timer.NewTimer(irq)
+	tm.Write(timer.ReloadRegister, 4)
+	tm.Write(timer.ControlRegister, timer.ControlEnable|timer.ControlPeriodic)
+
+	// It reloads each time it reaches zero
+	for period := 0; period < 3; period++ {
+		execute(tm, 4)
+		if tm.Read(timer.StatusRegister) != 1 {
+			t.Fatalf("period %d: status is not set", period)
+		}
+		if c := tm.Read(timer.CounterRegister); c != 4 {
+			t.Fatalf("period %d: counter %d, want 4", period, c)
+		}
+		tm.Write(timer.StatusRegister, 0)
+	}
+	execute(tm, 1)
+	if c := tm.Read(timer.CounterRegister); c != 3 || tm.Read(timer.StatusRegister) != 0 {
+		t.Errorf("counter %d, status %d, want 3 and 0", c, tm.Read(timer.StatusRegister))
+	}
+
+	// Interrupts are only raised with ControlInterrupt
+	if irq.Pending() != 0 {
+		t.Errorf("pending %x without ControlInterrupt", irq.Pending())
+	}
+
+	// Disabled timers do not count
+	tm.Write(timer.ControlRegister, timer.ControlPeriodic)
+	execute(tm, 10)
+	if c := tm.Read(timer.CounterRegister); c != 4 {
+		t.Errorf("counter %d while disabled, want 4", c)
+	}
+}
@@ -0,0 +1,224 @@
+// Package trace implements writing a trace of the instructions executed
+//   by the CPU.
+package trace
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/tteeoo/svc/cpu"
+	"github.com/tteeoo/svc/dat"
+	"github.com/tteeoo/svc/svb"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Range is an inclusive range of addresses.
+type Range struct {
+	Start uint16
+	End   uint16
+}
+
+// ParseRange parses a range of hex addresses like "900-9ff",
+//   or a single address.
+func ParseRange(s string) (Range, error) {
+	parts := strings.SplitN(s, "-", 2)
+	start, err := strconv.ParseUint(parts[0], 16, 16)
+	if err != nil {
+		return Range{}, fmt.Errorf("invalid address \"%s\"", parts[0])
+	}
+	end := start
+	if len(parts) == 2 {
+		end, err = strconv.ParseUint(parts[1], 16, 16)
+		if err != nil {
+			return Range{}, fmt.Errorf("invalid address \"%s\"", parts[1])
+		}
+	}
+	if end < start {
+		return Range{}, fmt.Errorf("range \"%s\" ends before it starts", s)
+	}
+	return Range{Start: uint16(start), End: uint16(end)}, nil
+}
+
+// Contains returns true if an address is in the range.
+func (r Range) Contains(address uint16) bool {
+	return address >= r.Start && address <= r.End
+}
+
+// Write is a memory write made by an instruction.
+type Write struct {
+	Address uint16 `json:"address"`
+	Value   uint16 `json:"value"`
+}
+
+// Entry describes an executed instruction.
+type Entry struct {
+	// Cycle is the number of instructions executed, including this one.
+	Cycle uint64 `json:"cycle"`
+	PC    uint16 `json:"pc"`
+	// Symbol is the address of the instruction relative to a symbol, if known.
+	Symbol string `json:"symbol,omitempty"`
+	Op     string `json:"op"`
+	// Operands holds the decoded operands: register names, and hex values,
+	//   with offsets converted to the addresses they are relative to.
+	Operands []string `json:"operands"`
+	// Registers maps the names of the registers that changed to their new values.
+	// The program counter is included only if the instruction jumped.
+	Registers map[string]uint16 `json:"registers,omitempty"`
+	Writes    []Write           `json:"writes,omitempty"`
+	// Error is the fault raised by the instruction, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// Tracer is an observer that writes an Entry for each executed instruction.
+// Interrupts serviced between instructions are not traced.
+type Tracer struct {
+	cpu.NopObserver
+	// JSON writes entries as lines of JSON instead of text.
+	JSON bool
+	// Ranges, if not empty, limits tracing to instructions in these ranges.
+	Ranges []Range
+	// Symbols, if set, are used to describe addresses.
+	Symbols svb.Symbols
+	w       *bufio.Writer
+	err     error
+	// active is true while a traced instruction executes.
+	active bool
+	regs   [dat.RegNum]uint16
+	writes []Write
+}
+
+// NewTracer returns a pointer to a new Tracer writing to w.
+// Flush must be called once tracing is done.
+func NewTracer(w io.Writer) *Tracer {
+	return &Tracer{
+		w: bufio.NewWriter(w),
+	}
+}
+
+// Flush writes any buffered entries, returning the first error
+//   encountered while writing.
+func (t *Tracer) Flush() error {
+	if err := t.w.Flush(); t.err == nil {
+		t.err = err
+	}
+	return t.err
+}
+
+// BeforeExecute implements cpu.Observer.
+func (t *Tracer) BeforeExecute(c *cpu.CPU, in cpu.Instruction) {
+	t.active = t.traced(in.PC)
+	if t.active {
+		t.regs = c.Regs
+		t.writes = t.writes[:0]
+	}
+}
+
+// MemoryWrite implements cpu.Observer.
+func (t *Tracer) MemoryWrite(address, value uint16) {
+	if t.active {
+		t.writes = append(t.writes, Write{Address: address, Value: value})
+	}
+}
+
+// AfterExecute implements cpu.Observer, writing the entry.
+func (t *Tracer) AfterExecute(c *cpu.CPU, in cpu.Instruction, err error) {
+	if !t.active || t.err != nil {
+		return
+	}
+	t.active = false
+	e := t.entry(c, in, err)
+	if t.JSON {
+		b, jerr := json.Marshal(e)
+		if jerr != nil {
+			t.err = jerr
+			return
+		}
+		b = append(b, '\n')
+		_, t.err = t.w.Write(b)
+	} else {
+		_, t.err = t.w.WriteString(t.text(e))
+	}
+}
+
+// traced returns true if the instruction at an address should be traced.
+func (t *Tracer) traced(address uint16) bool {
+	if len(t.Ranges) == 0 {
+		return true
+	}
+	for _, r := range t.Ranges {
+		if r.Contains(address) {
+			return true
+		}
+	}
+	return false
+}
+
+// entry creates the entry of an executed instruction.
+func (t *Tracer) entry(c *cpu.CPU, in cpu.Instruction, err error) Entry {
+	e := Entry{
+		Cycle:  c.Cycles,
+		PC:     in.PC,
+		Symbol: t.Symbols.Describe(in.PC),
+		Op:     in.Info.Name,
+	}
+	if e.Op == "" {
+		e.Op = fmt.Sprintf("%x", in.Word>>8)
+	}
+
+	// Decode operands
+	e.Operands = []string{}
+	next := in.PC + uint16(1+in.Info.Size)
+	for i, o := range in.Args() {
+		switch {
+		case i < in.Info.Packed && o < dat.RegNum:
+			e.Operands = append(e.Operands, dat.RegNumToName[o])
+		case i == in.Info.Packed && dat.OpNameToRelative[in.Info.Name]:
+			e.Operands = append(e.Operands, fmt.Sprintf("%x", next+o))
+		default:
+			e.Operands = append(e.Operands, fmt.Sprintf("%x", o))
+		}
+	}
+
+	// Find changes
+	for r, v := range c.Regs {
+		if v == t.regs[r] {
+			continue
+		}
+		if e.Registers == nil {
+			e.Registers = make(map[string]uint16)
+		}
+		e.Registers[dat.RegNumToName[r]] = v
+	}
+	if len(t.writes) > 0 {
+		e.Writes = append([]Write{}, t.writes...)
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	return e
+}
+
+// text formats an entry as a line of text.
+func (t *Tracer) text(e Entry) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%10d  %04x  ", e.Cycle, e.PC)
+	if t.Symbols != nil {
+		fmt.Fprintf(&b, "%-20s", e.Symbol)
+	}
+	fmt.Fprintf(&b, "%-24s", e.Op+" "+strings.Join(e.Operands, " "))
+	for r := uint16(0); r < dat.RegNum; r++ {
+		name := dat.RegNumToName[r]
+		if v, changed := e.Registers[name]; changed {
+			fmt.Fprintf(&b, "  %s=%04x", name, v)
+		}
+	}
+	for _, w := range e.Writes {
+		fmt.Fprintf(&b, "  [%04x]=%04x", w.Address, w.Value)
+	}
+	if e.Error != "" {
+		fmt.Fprintf(&b, "  %s", e.Error)
+	}
+	return strings.TrimRight(b.String(), " ") + "\n"
+}
@@ -5,8 +5,14 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"github.com/tteeoo/svc/cpu"
+	"github.com/tteeoo/svc/mem"
+	"io/ioutil"
+	"strconv"
+	"strings"
 )
 
 // UintToBytes converts a uint16 to two bytes.
@@ -46,10 +52,14 @@ func AddressToSection(c *cpu.CPU, a uint16) string {
 		return "text"
 	} else if a < c.Mem.StackMax+1 {
 		return "stak"
+	} else if a < c.Mem.ProgramOffset {
+		return "free"
 	} else if a < c.Mem.HeapOffset {
 		return "prog"
-	} else {
+	} else if a < c.Mem.SystemOffset {
 		return "heap"
+	} else {
+		return "syst"
 	}
 }
 
@@ -65,6 +75,95 @@ func SectionToColor(s string) string {
 		ansic = "32;1"
 	case "heap":
 		ansic = "33;1"
+	case "syst":
+		ansic = "34;1"
+	case "free":
+		ansic = "37;1"
 	}
 	return ansic
 }
+
+// StringList is a flag.Value that collects repeated string flags.
+type StringList []string
+
+// String joins the collected strings.
+func (l *StringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set appends a string to the list.
+func (l *StringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// LayoutFlags defines flags for choosing a memory layout.
+// The returned function should be called after the flags are parsed,
+//   and returns the chosen layout and whether any of the flags were set.
+func LayoutFlags() func() (mem.Layout, bool, error) {
+	file := flag.String("layout", "", "read the memory layout from a JSON file")
+	vga := flag.String("vga", "", "VGA text buffer dimensions (<width>x<height>)")
+	stack := flag.Int("stack", 0, "stack size in words")
+	program := flag.String("program", "", "address (hex) programs are loaded at")
+	system := flag.String("system", "", "address (hex) of the reserved system words")
+
+	return func() (mem.Layout, bool, error) {
+		l := mem.DefaultLayout()
+		set := false
+
+		// Read layout file
+		if *file != "" {
+			b, err := ioutil.ReadFile(*file)
+			if err != nil {
+				return l, false, err
+			}
+			if err := json.Unmarshal(b, &l); err != nil {
+				return l, false, fmt.Errorf("cannot parse layout file: %s", err)
+			}
+			set = true
+		}
+
+		// Override with flags
+		if *vga != "" {
+			dims := strings.Split(*vga, "x")
+			if len(dims) != 2 {
+				return l, false, fmt.Errorf("invalid VGA dimensions \"%s\"", *vga)
+			}
+			w, err := strconv.Atoi(dims[0])
+			if err != nil {
+				return l, false, fmt.Errorf("invalid VGA dimensions \"%s\"", *vga)
+			}
+			h, err := strconv.Atoi(dims[1])
+			if err != nil {
+				return l, false, fmt.Errorf("invalid VGA dimensions \"%s\"", *vga)
+			}
+			l.VGAWidth, l.VGAHeight = w, h
+			set = true
+		}
+		if *stack != 0 {
+			if *stack < 1 || *stack > 0xffff {
+				return l, false, fmt.Errorf("invalid stack size %d", *stack)
+			}
+			l.StackSize = uint16(*stack)
+			set = true
+		}
+		if *program != "" {
+			a, err := ParseHex(*program)
+			if err != nil {
+				return l, false, err
+			}
+			l.ProgramOffset = a
+			set = true
+		}
+		if *system != "" {
+			a, err := ParseHex(*system)
+			if err != nil {
+				return l, false, err
+			}
+			l.SystemOffset = a
+			set = true
+		}
+
+		return l, set, l.Validate()
+	}
+}
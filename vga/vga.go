@@ -31,7 +31,7 @@ func (v *VGA) TextDraw() {
 		tb[i] = make([][2]byte, v.Mem.VGAWidth)
 	}
 	// Populate
-	a := uint16(0)
+	a := v.Mem.VGAOffset
 	for i := 0; i < v.Mem.VGAHeight; i++ {
 		for j := 0; j < v.Mem.VGAWidth; j++ {
 			b := v.Mem.Get(a)
@@ -157,3 +157,11 @@ func (v *VGA) TextDraw() {
 	}
 	print(realOut + final)
 }
+
+// Redraw prints the last rendered buffer again, such as after it was restored.
+func (v *VGA) Redraw() {
+	if len(v.LastBuffer) == 0 {
+		return
+	}
+	print("\033[2J\033[H" + strings.Join(v.LastBuffer, "\n"))
+}